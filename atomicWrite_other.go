@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+// Directories can't be synced here, a rename is as durable as the system
+// makes it.
+
+func syncDir(dir string) error {
+	return nil
+}
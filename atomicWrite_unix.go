@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import "os"
+
+// syncDir flushes the entries of a directory, like a rename into it, to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
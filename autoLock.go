@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// An open vault locks itself after it wasn't used for a while. The timeout
+// is a setting of each vault, vaults without one use defaultAutoLock. It can
+// be changed with the CIPHERY_AUTO_LOCK environment variable, a duration like
+// 10m or "off".
+var defaultAutoLock = 5 * time.Minute
+
+func init() {
+	value := os.Getenv("CIPHERY_AUTO_LOCK")
+	if value == "" {
+		return
+	}
+	timeout, err := parseTimeout(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring CIPHERY_AUTO_LOCK: %v\n", err)
+		return
+	}
+	defaultAutoLock = timeout
+}
+
+// parseTimeout parses a timeout like the auto lock one. "off" is returned as
+// a zero timeout.
+func parseTimeout(value string) (time.Duration, error) {
+	if value == "off" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if timeout < time.Second {
+		return 0, fmt.Errorf("timeout must be at least a second")
+	}
+	return timeout, nil
+}
+
+// AutoLockTimeout returns how long the vault stays open without being used,
+// zero if it doesn't lock itself.
+func (v Vault) AutoLockTimeout() time.Duration {
+	switch {
+	case v.AutoLockSeconds < 0:
+		return 0
+	case v.AutoLockSeconds > 0:
+		return time.Duration(v.AutoLockSeconds) * time.Second
+	default:
+		return defaultAutoLock
+	}
+}
+
+// SetAutoLock changes the auto lock timeout of an open vault and saves it. A
+// zero timeout uses the default, a negative one turns auto lock off.
+func SetAutoLock(vault Vault, timeout time.Duration) (Vault, error) {
+	changed := vault
+	changed.AutoLockSeconds = int(timeout / time.Second)
+	if timeout < 0 {
+		changed.AutoLockSeconds = -1
+	}
+	saved, err := SaveVault(changed)
+	if err != nil {
+		return vault, err
+	}
+	return saved, nil
+}
+
+type AutoLockTickMsg struct {
+	generation int
+}
+
+func autoLockTick(generation int, after time.Duration) tea.Cmd {
+	return tea.Tick(after, func(time.Time) tea.Msg {
+		return AutoLockTickMsg{generation: generation}
+	})
+}
+
+// startAutoLock starts the idle timer of a vault that was just opened. Timers
+// of vaults opened before die off.
+func (m *mainModel) startAutoLock(vault Vault) tea.Cmd {
+	m.autoLockGeneration++
+	m.lastActivity = time.Now()
+	timeout := vault.AutoLockTimeout()
+	if timeout == 0 {
+		return nil
+	}
+	return autoLockTick(m.autoLockGeneration, timeout)
+}
+
+// stopAutoLock stops the idle timer when the vault is left.
+func (m *mainModel) stopAutoLock() {
+	m.autoLockGeneration++
+}
+
+// autoLock is called first in Update of every view that shows an open vault.
+// It notes key presses as activity, locks the vault on the lock key and
+// when the idle timer runs out. If it reports true, Update returns the model
+// and command it returned. Views that are busy keep the vault open until
+// they're done.
+func (m *mainModel) autoLock(view tea.Model, msg tea.Msg, vault Vault, busy bool) (tea.Model, tea.Cmd, bool) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if key.Matches(msg, keysVault.Lock) && !busy {
+			model, cmd := m.lockVault(vault, "Vault locked.")
+			return model, cmd, true
+		}
+		m.lastActivity = time.Now()
+	case AutoLockTickMsg:
+		if msg.generation != m.autoLockGeneration {
+			return view, nil, true
+		}
+		timeout := vault.AutoLockTimeout()
+		if timeout == 0 {
+			return view, nil, true
+		}
+		idle := time.Since(m.lastActivity)
+		if busy || idle < timeout {
+			return view, autoLockTick(msg.generation, max(timeout-idle, time.Second)), true
+		}
+		model, cmd := m.lockVault(vault, fmt.Sprintf("Vault locked after %s without use.", timeout))
+		return model, cmd, true
+	}
+	return view, nil, false
+}
+
+// lockVault drops the vault key and everything decrypted with it and goes
+// back to the unlock screen of the same vault.
+func (m *mainModel) lockVault(vault Vault, reason string) (tea.Model, tea.Cmd) {
+	m.stopAutoLock()
+	DestroyVaultBuffers(vault.vaultKey)
+
+	locked, err := LoadVault(vault.FileName())
+	if err != nil {
+		locked = vault
+		locked.vaultKey = nil
+	}
+
+	ClearClipboard()
+
+	// Reset every view that held the open vault
+	m.vaultView = InitialVaultModel(m)
+	m.createSecretView = InitialCreateSecretModel(m)
+	m.changePasswordView = InitialChangePasswordModel(m)
+	m.rotateKeyView = InitialRotateKeyModel(m)
+	m.keySlotsView = InitialKeySlotsModel(m)
+	m.enterVaultView = InitialEnterVaultModel(m)
+	m.generatorView = InitialGeneratorModel(m)
+	m.conflictView = InitialConflictModel(m)
+
+	m.viewState = enterVaultView
+	return m.enterVaultView, tea.Batch(tea.ClearScreen, tea.WindowSize(), textinput.Blink, SendVaultCmd(locked), SendVaultLockedCmd(reason))
+}
+
+// Telling the enter vault view why the vault was locked.
+type SendVaultLockedMsg string
+
+func SendVaultLockedCmd(reason string) tea.Cmd {
+	return func() tea.Msg {
+		return SendVaultLockedMsg(reason)
+	}
+}
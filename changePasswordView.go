@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	lg "github.com/charmbracelet/lipgloss"
+)
+
+type ChangePasswordModel struct {
+	keys      keyMap
+	help      help.Model
+	w, h      int
+	mainModel *mainModel
+
+	errorMsg   string
+	focusIndex int
+	inputs     []textinput.Model
+
+	decryptedVaultKey *LockedBuffer
+	vault             Vault
+
+	// Set when the vault was opened with a recovery code. A new master
+	// password has to be chosen and there's no going back.
+	recovering      bool
+	recoverySlot    int
+	recoveryKeyfile []byte
+}
+
+const (
+	oldPassword = iota
+	newPassword
+	reNewPassword
+	changeKeyfile
+)
+
+func InitialChangePasswordModel(mainmdl *mainModel) ChangePasswordModel {
+	m := ChangePasswordModel{
+		keys:      keysChangePassword,
+		help:      help.New(),
+		mainModel: mainmdl,
+		inputs:    make([]textinput.Model, 3)}
+
+	var t textinput.Model
+	for i := range m.inputs {
+		t = textinput.New()
+		t.Cursor.Style = cursorStyle
+		t.EchoMode = textinput.EchoPassword
+		t.EchoCharacter = '•'
+
+		switch i {
+		case oldPassword:
+			t.Placeholder = "Current password"
+			t.Focus()
+			t.PromptStyle = focusedStyle
+			t.TextStyle = focusedStyle
+		case newPassword:
+			t.Placeholder = "New password"
+		case reNewPassword:
+			t.Placeholder = "Re-enter new password"
+		}
+		m.inputs[i] = t
+	}
+	return m
+}
+
+func (m ChangePasswordModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m ChangePasswordModel) View() string {
+	s := ""
+	if m.recovering {
+		s += titleStyle.Render(fmt.Sprintf("New master password for %s", highlightStyle.Render(m.vault.Name)))
+		s += "\n"
+		s += "You opened the vault with a recovery code. Choose a new master password,\n"
+		s += "the code is burned once it's set.\n"
+		if m.recoveryAsksKeyfile() {
+			s += "The master password needs a keyfile, the new one keeps needing it.\n"
+		}
+	} else {
+		s += titleStyle.Render(fmt.Sprintf("Change password of %s", highlightStyle.Render(m.vault.Name)))
+		s += "\n"
+	}
+
+	var b strings.Builder
+
+	first, last := m.inputRange()
+	for i := first; i <= last; i++ {
+		input := m.inputs[i]
+		if i == changeKeyfile && m.recovering {
+			input.Placeholder = "Keyfile path"
+		}
+		b.WriteString(input.View())
+		if i < last {
+			b.WriteRune('\n')
+		}
+	}
+	s += formBorderStyle.Render(b.String())
+	s += "\n"
+	s += fmt.Sprintf("Press %s to change password.\n", highlightStyle.Render("enter"))
+	s += errorStyle.Render(fmt.Sprintf("%s\n", m.errorMsg))
+
+	helpView := m.help.View(m.keys)
+	s += helpStyle.Render(helpView)
+	s = lg.Place(m.w, m.h, lg.Center, lg.Center, s)
+	return s
+}
+
+func (m ChangePasswordModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if model, cmd, ok := m.mainModel.autoLock(m, msg, m.vault, false); ok {
+		return model, cmd
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		case key.Matches(msg, m.keys.Back) && !m.recovering:
+			m.mainModel.changePasswordView = InitialChangePasswordModel(m.mainModel)
+			m.mainModel.viewState = vaultView
+			return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey))
+		case key.Matches(msg, m.keys.Enter):
+			return m.handleChange()
+		case key.Matches(msg, m.keys.Up) || key.Matches(msg, m.keys.Down):
+			// Cycle indexes
+			if key.Matches(msg, m.keys.Up) {
+				m.focusIndex--
+			} else if key.Matches(msg, m.keys.Down) {
+				m.focusIndex++
+			}
+
+			first, last := m.inputRange()
+			if m.focusIndex > last {
+				m.focusIndex = first
+			} else if m.focusIndex < first {
+				m.focusIndex = last
+			}
+
+			cmds := make([]tea.Cmd, len(m.inputs))
+			for i := 0; i < len(m.inputs); i++ {
+				if i == m.focusIndex {
+					// Set focused state
+					cmds[i] = m.inputs[i].Focus()
+					m.inputs[i].PromptStyle = focusedStyle
+					m.inputs[i].TextStyle = focusedStyle
+					continue
+				}
+				// Remove focused state
+				m.inputs[i].Blur()
+				m.inputs[i].PromptStyle = noStyle
+				m.inputs[i].TextStyle = noStyle
+			}
+
+			return m, tea.Batch(cmds...)
+		}
+	case SendRecoveryMsg:
+		m.recovering = true
+		m.recoverySlot = msg.Slot
+		m.recoveryKeyfile = msg.Keyfile
+		m.focusIndex = newPassword
+		m.inputs[oldPassword].Blur()
+		m.inputs[oldPassword].PromptStyle = noStyle
+		m.inputs[oldPassword].TextStyle = noStyle
+		m.inputs[newPassword].PromptStyle = focusedStyle
+		m.inputs[newPassword].TextStyle = focusedStyle
+		return m, m.inputs[newPassword].Focus()
+	case SendDecryptedVaultKeyMsg:
+		m.decryptedVaultKey = msg.VaultKey
+		return m, nil
+	case SendFailedAttemptsMsg:
+		m.errorMsg = failedAttemptsNotice(int(msg))
+		return m, nil
+	case SendVaultMsg:
+		m.vault = msg.VaultSended
+		if m.vault.RequiresKeyfile() && len(m.inputs) == changeKeyfile {
+			m.inputs = append(m.inputs, newKeyfileInput())
+		}
+		return m, nil
+	case tea.WindowSizeMsg:
+		m.w = msg.Width
+		m.h = msg.Height
+		m.help.Width = msg.Width
+	}
+
+	// Handle character input and blinking
+	cmd := m.updateInputs(msg)
+	return m, cmd
+}
+
+func (m *ChangePasswordModel) updateInputs(msg tea.Msg) tea.Cmd {
+	cmds := make([]tea.Cmd, len(m.inputs))
+
+	// Only text inputs with Focus() set will respond, so it's safe to simply
+	// update all of them here without any further logic.
+	for i := range m.inputs {
+		m.inputs[i], cmds[i] = m.inputs[i].Update(msg)
+	}
+
+	return tea.Batch(cmds...)
+}
+
+// inputRange returns the first and last input shown. Recovering only asks
+// for the new password, and for the keyfile if the master password needs one
+// and it wasn't given when unlocking.
+func (m ChangePasswordModel) inputRange() (int, int) {
+	if m.recovering && m.recoveryAsksKeyfile() {
+		return newPassword, changeKeyfile
+	} else if m.recovering {
+		return newPassword, reNewPassword
+	}
+	return 0, len(m.inputs) - 1
+}
+
+func (m ChangePasswordModel) recoveryAsksKeyfile() bool {
+	return m.recoveryKeyfile == nil && MasterNeedsKeyfile(m.vault) && len(m.inputs) > changeKeyfile
+}
+
+// Sending the recovery code slot a vault was opened with to the change
+// password view.
+type SendRecoveryMsg struct {
+	Slot    int
+	Keyfile []byte
+}
+
+func SendRecoveryCmd(slot int, keyfile []byte) tea.Cmd {
+	return func() tea.Msg {
+		return SendRecoveryMsg{Slot: slot, Keyfile: keyfile}
+	}
+}
+
+func (m ChangePasswordModel) handleChange() (tea.Model, tea.Cmd) {
+	first, last := m.inputRange()
+	for i := first; i <= last; i++ {
+		if i != changeKeyfile && len(m.inputs[i].Value()) == 0 {
+			m.errorMsg = fmt.Sprintf("[%s] option can't be empty!", m.inputs[i].Placeholder)
+			return m, nil
+		}
+	}
+	if ok, errMsg := MasterPasswordValidation(m.inputs[newPassword].Value(), m.inputs[reNewPassword].Value()); !ok {
+		m.errorMsg = errMsg
+		return m, nil
+	}
+	if ok, errMsg := PasswordStrengthValidation(m.inputs[newPassword].Value(), m.vault.MinPasswordScore); !ok {
+		m.errorMsg = errMsg
+		return m, nil
+	}
+
+	if m.recovering {
+		return m.handleRecover()
+	}
+
+	keyfilePath := ""
+	if len(m.inputs) > changeKeyfile {
+		keyfilePath = strings.TrimSpace(m.inputs[changeKeyfile].Value())
+	}
+	creds, err := NewCredentials(m.inputs[oldPassword].Value(), keyfilePath)
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error reading keyfile: %v", err)
+		return m, nil
+	}
+
+	changedVault, err := ChangeVaultPassword(m.vault, creds, m.inputs[newPassword].Value())
+	if model, cmd, ok := m.mainModel.vaultConflict(err, m.vault, m.decryptedVaultKey); ok {
+		m.mainModel.changePasswordView = InitialChangePasswordModel(m.mainModel)
+		return model, cmd
+	} else if errors.Is(err, ErrWrongPassword) {
+		m.errorMsg = "Wrong master password!"
+		return m, nil
+	} else if errors.Is(err, ErrUnlockThrottled) {
+		m.errorMsg = err.Error()
+		return m, nil
+	} else if err != nil {
+		m.errorMsg = fmt.Sprintf("Error changing password: %v", err)
+		return m, nil
+	}
+
+	// Reset the view
+	m.mainModel.changePasswordView = InitialChangePasswordModel(m.mainModel)
+
+	m.mainModel.viewState = vaultView
+	return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(changedVault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey), SendConfirmationCmd("Master password changed successfully"))
+}
+
+func (m ChangePasswordModel) handleRecover() (tea.Model, tea.Cmd) {
+	creds := Credentials{Password: m.inputs[newPassword].Value(), Keyfile: m.recoveryKeyfile}
+	if m.recoveryAsksKeyfile() {
+		keyfilePath := strings.TrimSpace(m.inputs[changeKeyfile].Value())
+		if keyfilePath == "" {
+			m.errorMsg = "The master password of this vault needs a keyfile, enter its path!"
+			return m, nil
+		}
+		keyfile, err := ReadKeyfile(keyfilePath)
+		if err != nil {
+			m.errorMsg = fmt.Sprintf("Error reading keyfile: %v", err)
+			return m, nil
+		}
+		creds.Keyfile = keyfile
+	}
+
+	recoveredVault, err := RecoverVault(m.vault, m.recoverySlot, m.decryptedVaultKey, creds)
+	if model, cmd, ok := m.mainModel.vaultConflict(err, m.vault, m.decryptedVaultKey); ok {
+		m.mainModel.changePasswordView = InitialChangePasswordModel(m.mainModel)
+		return model, cmd
+	} else if err != nil {
+		m.errorMsg = fmt.Sprintf("Error setting new password: %v", err)
+		return m, nil
+	}
+
+	// Reset the view
+	m.mainModel.changePasswordView = InitialChangePasswordModel(m.mainModel)
+
+	m.mainModel.viewState = vaultView
+	return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(recoveredVault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey), SendConfirmationCmd("New master password set, the recovery code is burned"))
+}
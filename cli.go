@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+)
+
+const cliUsage = `Usage:
+  ciphery                     start the app
+  ciphery passwd <vault>      change the master password of a vault
+  ciphery rotate <vault>      re-encrypt every secret under a new vault key
+  ciphery slots list <vault>
+  ciphery slots add-password [-label l] <vault>
+  ciphery slots add-keyfile -file path [-label l] <vault>
+  ciphery slots recovery-codes <vault>
+  ciphery slots remove -id id <vault>
+                              manage the key slots that unlock a vault
+  ciphery keyfile <path>      write a new random keyfile
+  ciphery recover <vault>     set a new master password using a recovery code
+  ciphery seal [-pad] <vault> hide the name, description and secrets of a vault
+                              until it is unlocked, -pad also hides their size
+  ciphery autolock -after d <vault>
+                              lock the vault in the app after it wasn't used
+                              for d (like 10m), "off" or "default"
+  ciphery clipboard -clear d <vault>
+                              clear secrets copied in the app from the
+                              clipboard after d (like 1m), "off" or "default"
+  ciphery sensitive -confirm on|off <vault>
+                              ask for the master password before a secret
+                              marked sensitive is revealed, copied or edited
+  ciphery strength            check how strong a password is
+  ciphery strength -min s <vault>
+                              refuse new master passwords weaker than s, from
+                              very-weak (0) to very-strong (4)
+  ciphery generate [-length n] [-no-symbols] [-no-ambiguous]
+  ciphery generate -passphrase [-words n] [-separator s] [-caps c]
+                              print a random password, or a passphrase of
+                              words from the EFF list, see generate -h
+  ciphery convert -cipher c <vault>
+                              encrypt every secret again with another cipher,
+                              aes-256-gcm or xchacha20-poly1305
+
+Commands that unlock a vault take -keyfile path when the vault needs one.
+--vaults-dir dir before the command, or CIPHERY_VAULTS_DIR, picks the vaults
+directory, see the README.
+`
+
+// runCLI handles the command line subcommands and returns the exit code.
+func runCLI(args []string) int {
+	defer DestroyLockedBuffers()
+
+	var err error
+	switch args[0] {
+	case "passwd":
+		err = cliChangePassword(args[1:])
+	case "rotate":
+		err = cliRotateKey(args[1:])
+	case "slots":
+		err = cliKeySlots(args[1:])
+	case "keyfile":
+		err = cliGenerateKeyfile(args[1:])
+	case "recover":
+		err = cliRecover(args[1:])
+	case "seal":
+		err = cliSeal(args[1:])
+	case "convert":
+		err = cliConvert(args[1:])
+	case "autolock":
+		err = cliAutoLock(args[1:])
+	case "clipboard":
+		err = cliClipboard(args[1:])
+	case "sensitive":
+		err = cliSensitive(args[1:])
+	case "strength":
+		err = cliStrength(args[1:])
+	case "generate":
+		err = cliGenerate(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], cliUsage)
+		return 2
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseVaultArgs parses the flags of a subcommand and returns the vault name
+// given either with -vault or as the first positional argument, and the
+// keyfile given with -keyfile.
+func parseVaultArgs(fs *flag.FlagSet, args []string) (string, string, error) {
+	vaultName := fs.String("vault", "", "name of the vault")
+	keyfilePath := fs.String("keyfile", "", "keyfile needed to unlock the vault")
+	if err := fs.Parse(args); err != nil {
+		return "", "", err
+	}
+	if *vaultName == "" {
+		*vaultName = fs.Arg(0)
+	}
+	if *vaultName == "" {
+		return "", "", fmt.Errorf("no vault given")
+	}
+	return *vaultName, *keyfilePath, nil
+}
+
+// promptCredentials asks for a password and bundles it with the keyfile.
+func promptCredentials(prompt, keyfilePath string) (Credentials, error) {
+	password, err := readPassword(prompt)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return NewCredentials(password, keyfilePath)
+}
+
+func cliChangePassword(args []string) error {
+	fs := flag.NewFlagSet("passwd", flag.ContinueOnError)
+	vaultName, keyfilePath, err := parseVaultArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	vault, err := LoadVault(vaultName)
+	if err != nil {
+		return err
+	}
+
+	oldCreds, err := promptCredentials("Current password: ", keyfilePath)
+	if err != nil {
+		return err
+	}
+	newPassword, err := readPassword("New password: ")
+	if err != nil {
+		return err
+	}
+	reNewPassword, err := readPassword("Re-enter new password: ")
+	if err != nil {
+		return err
+	}
+	if ok, errMsg := MasterPasswordValidation(newPassword, reNewPassword); !ok {
+		return errors.New(errMsg)
+	}
+	if ok, errMsg := PasswordStrengthValidation(newPassword, vault.MinPasswordScore); !ok {
+		return errors.New(errMsg)
+	}
+
+	if err := unlockVerifyCLI(vault, oldCreds); err != nil {
+		return err
+	}
+	if _, err := ChangeVaultPassword(vault, oldCreds, newPassword); err != nil {
+		return err
+	}
+	fmt.Printf("Master password of %s changed successfully\n", vaultName)
+	return nil
+}
+
+func cliRotateKey(args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	vaultName, keyfilePath, err := parseVaultArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	vault, err := LoadVault(vaultName)
+	if err != nil {
+		return err
+	}
+
+	creds, err := promptCredentials("Master password: ", keyfilePath)
+	if err != nil {
+		return err
+	}
+	if err := unlockVerifyCLI(vault, creds); err != nil {
+		return err
+	}
+
+	showedProgress := false
+	rotated, vaultKey, dropped, err := RotateVaultKey(vault, creds, func(done, total int) error {
+		fmt.Fprintf(os.Stderr, "\rRe-encrypting secrets %d/%d", done, total)
+		showedProgress = true
+		return nil
+	})
+	if showedProgress {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("vault left unchanged: %w", err)
+	}
+	defer vaultKey.Destroy()
+	fmt.Printf("Vault key of %s rotated successfully\n", vaultName)
+	if len(dropped) == 0 {
+		return nil
+	}
+	fmt.Println("Removed the key slots the given credentials don't open:")
+	for _, slot := range dropped {
+		fmt.Printf("  %s\n", slot.describe())
+	}
+	if !hasRecoverySlot(dropped) {
+		return nil
+	}
+
+	generate, err := askYesNo("Generate new recovery codes now? [y/N] ")
+	if err != nil || !generate {
+		fmt.Printf("Run \"ciphery slots recovery-codes %s\" to get new ones later\n", vaultName)
+		return err
+	}
+	_, codes, err := GenerateRecoveryCodes(rotated, vaultKey)
+	if err != nil {
+		return err
+	}
+	fmt.Println("New recovery codes. Write them down now:")
+	for _, code := range codes {
+		fmt.Println(code)
+	}
+	return nil
+}
+
+// unlockVaultCLI loads a vault and prompts for whatever is needed to open it.
+// The credentials that opened it are returned too.
+func unlockVaultCLI(vaultName, keyfilePath string) (Vault, *LockedBuffer, Credentials, error) {
+	vault, err := LoadVault(vaultName)
+	if err != nil {
+		return vault, nil, Credentials{}, err
+	}
+
+	creds, err := promptCredentials("Master password: ", keyfilePath)
+	if err != nil {
+		return vault, nil, creds, err
+	}
+	vaultKey, slotIndex, failedAttempts, err := ThrottledUnlock(vault, creds)
+	if err != nil {
+		return vault, nil, creds, err
+	}
+	reportFailedAttempts(failedAttempts)
+	if err := VerifyVault(vault, vaultKey.Bytes()); err != nil {
+		return vault, nil, creds, fmt.Errorf("%w, open it in the app to look at it", err)
+	}
+	vault, err = OpenVault(vault, vaultKey)
+	if err != nil {
+		return vault, nil, creds, err
+	}
+	vault, err = MigrateVault(vault, vaultKey)
+	if err != nil {
+		return vault, nil, creds, err
+	}
+	if vault.KeySlots[slotIndex].Type == slotRecovery {
+		vault, err = recoverCLI(vault, slotIndex, vaultKey, creds.Keyfile)
+		// The recovery code is burned, the new password opens the vault
+		creds.Password = ""
+	}
+	return vault, vaultKey, creds, err
+}
+
+// unlockVerifyCLI checks the credentials and the file before a command that
+// unlocks the vault itself goes on.
+func unlockVerifyCLI(vault Vault, creds Credentials) error {
+	vaultKey, _, failedAttempts, err := ThrottledUnlock(vault, creds)
+	if err != nil {
+		return err
+	}
+	defer vaultKey.Destroy()
+	reportFailedAttempts(failedAttempts)
+	if err := VerifyVault(vault, vaultKey.Bytes()); err != nil {
+		return fmt.Errorf("%w, open it in the app to look at it", err)
+	}
+	return nil
+}
+
+func reportFailedAttempts(failedAttempts int) {
+	if failedAttempts > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", failedAttemptsNotice(failedAttempts))
+	}
+}
+
+// recoverCLI makes the user choose a new master password after a recovery
+// code was used, which burns the code.
+func recoverCLI(vault Vault, slotIndex int, vaultKey *LockedBuffer, keyfile []byte) (Vault, error) {
+	fmt.Fprintln(os.Stderr, "Opened with a recovery code, a new master password has to be set.")
+	if keyfile == nil && MasterNeedsKeyfile(vault) {
+		return vault, fmt.Errorf("%w, run again with --keyfile <path>", ErrRecoveryKeyfile)
+	}
+	newPassword, err := readPassword("New master password: ")
+	if err != nil {
+		return vault, err
+	}
+	reNewPassword, err := readPassword("Re-enter new master password: ")
+	if err != nil {
+		return vault, err
+	}
+	if ok, errMsg := MasterPasswordValidation(newPassword, reNewPassword); !ok {
+		return vault, errors.New(errMsg)
+	}
+	if ok, errMsg := PasswordStrengthValidation(newPassword, vault.MinPasswordScore); !ok {
+		return vault, errors.New(errMsg)
+	}
+
+	vault, err = RecoverVault(vault, slotIndex, vaultKey, Credentials{Password: newPassword, Keyfile: keyfile})
+	if err != nil {
+		return vault, err
+	}
+	fmt.Fprintln(os.Stderr, "New master password set, the recovery code is burned.")
+	return vault, nil
+}
+
+func cliRecover(args []string) error {
+	fs := flag.NewFlagSet("recover", flag.ContinueOnError)
+	vaultName, keyfilePath, err := parseVaultArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	vault, err := LoadVault(vaultName)
+	if err != nil {
+		return err
+	}
+	creds, err := promptCredentials("Recovery code: ", keyfilePath)
+	if err != nil {
+		return err
+	}
+	if _, ok := normalizeRecoveryCode(creds.Password); !ok {
+		return fmt.Errorf("that isn't a recovery code")
+	}
+
+	vaultKey, slotIndex, failedAttempts, err := ThrottledUnlock(vault, creds)
+	if errors.Is(err, ErrUnlockThrottled) {
+		return err
+	} else if err != nil || vault.KeySlots[slotIndex].Type != slotRecovery {
+		return fmt.Errorf("wrong recovery code")
+	}
+	defer vaultKey.Destroy()
+	reportFailedAttempts(failedAttempts)
+	if err := VerifyVault(vault, vaultKey.Bytes()); err != nil {
+		return fmt.Errorf("%w, open it in the app to look at it", err)
+	}
+	_, err = recoverCLI(vault, slotIndex, vaultKey, creds.Keyfile)
+	return err
+}
+
+func cliSeal(args []string) error {
+	fs := flag.NewFlagSet("seal", flag.ContinueOnError)
+	pad := fs.Bool("pad", false, "pad the sealed contents to hide their size")
+	vaultName, keyfilePath, err := parseVaultArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	vault, vaultKey, _, err := unlockVaultCLI(vaultName, keyfilePath)
+	if err != nil {
+		return err
+	}
+	sealed, err := SealVault(vault, vaultKey, *pad)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Vault %s sealed, from now on it is called %s on the command line\n", vault.Name, sealed.FileName())
+	return nil
+}
+
+func cliConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	cipherName := fs.String("cipher", "", "cipher to convert to: "+strings.Join(ciphers, " or "))
+	vaultName, keyfilePath, err := parseVaultArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if !validCipher(*cipherName) {
+		return fmt.Errorf("-cipher must be %s", strings.Join(ciphers, " or "))
+	}
+
+	vault, _, creds, err := unlockVaultCLI(vaultName, keyfilePath)
+	if err != nil {
+		return err
+	}
+	if vault.CipherName() == *cipherName {
+		return fmt.Errorf("vault already uses %s", *cipherName)
+	}
+
+	showedProgress := false
+	_, err = ConvertVaultCipher(vault, creds, *cipherName, func(done, total int) error {
+		fmt.Fprintf(os.Stderr, "\rRe-encrypting secrets %d/%d", done, total)
+		showedProgress = true
+		return nil
+	})
+	if showedProgress {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("vault left unchanged: %w", err)
+	}
+
+	fmt.Printf("Vault %s now uses %s\n", vaultName, *cipherName)
+	return nil
+}
+
+func cliAutoLock(args []string) error {
+	fs := flag.NewFlagSet("autolock", flag.ContinueOnError)
+	after := fs.String("after", "", `idle time before the vault locks, "off" or "default"`)
+	vaultName, keyfilePath, err := parseVaultArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	var timeout time.Duration
+	switch *after {
+	case "":
+		return fmt.Errorf("-after is required")
+	case "default":
+	case "off":
+		timeout = -1
+	default:
+		timeout, err = parseTimeout(*after)
+		if err != nil {
+			return err
+		}
+	}
+
+	vault, _, _, err := unlockVaultCLI(vaultName, keyfilePath)
+	if err != nil {
+		return err
+	}
+	vault, err = SetAutoLock(vault, timeout)
+	if err != nil {
+		return err
+	}
+	if lockAfter := vault.AutoLockTimeout(); lockAfter == 0 {
+		fmt.Printf("Vault %s no longer locks on its own\n", vaultName)
+	} else {
+		fmt.Printf("Vault %s locks after %s without use\n", vaultName, lockAfter)
+	}
+	return nil
+}
+
+func cliClipboard(args []string) error {
+	fs := flag.NewFlagSet("clipboard", flag.ContinueOnError)
+	clearAfter := fs.String("clear", "", `time before copied secrets are cleared, "off" or "default"`)
+	vaultName, keyfilePath, err := parseVaultArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	var timeout time.Duration
+	switch *clearAfter {
+	case "":
+		return fmt.Errorf("-clear is required")
+	case "default":
+	case "off":
+		timeout = -1
+	default:
+		timeout, err = parseTimeout(*clearAfter)
+		if err != nil {
+			return err
+		}
+	}
+
+	vault, _, _, err := unlockVaultCLI(vaultName, keyfilePath)
+	if err != nil {
+		return err
+	}
+	vault, err = SetClipboardClear(vault, timeout)
+	if err != nil {
+		return err
+	}
+	if clearAfter := vault.ClipboardClearTimeout(); clearAfter == 0 {
+		fmt.Printf("Secrets copied from vault %s stay in the clipboard\n", vaultName)
+	} else {
+		fmt.Printf("Secrets copied from vault %s are cleared after %s\n", vaultName, clearAfter)
+	}
+	return nil
+}
+
+func cliSensitive(args []string) error {
+	fs := flag.NewFlagSet("sensitive", flag.ContinueOnError)
+	confirm := fs.String("confirm", "", "on or off")
+	vaultName, keyfilePath, err := parseVaultArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *confirm != "on" && *confirm != "off" {
+		return fmt.Errorf("-confirm must be on or off")
+	}
+
+	vault, _, _, err := unlockVaultCLI(vaultName, keyfilePath)
+	if err != nil {
+		return err
+	}
+	if _, err := SetConfirmSensitive(vault, *confirm == "on"); err != nil {
+		return err
+	}
+	if *confirm == "on" {
+		fmt.Printf("Vault %s now asks for the master password before revealing sensitive secrets\n", vaultName)
+	} else {
+		fmt.Printf("Vault %s reveals sensitive secrets without asking\n", vaultName)
+	}
+	return nil
+}
+
+func cliStrength(args []string) error {
+	if len(args) == 0 {
+		password, err := readPassword("Password: ")
+		if err != nil {
+			return err
+		}
+		strength := EstimateStrength(normalizePassword(password))
+		fmt.Printf("Strength: %s, about %.0f bits\n", scoreNames[strength.Score], strength.Bits)
+		if strength.Warning != "" {
+			fmt.Println(strength.Warning)
+		}
+		for _, suggestion := range strength.Suggestions {
+			fmt.Printf("- %s\n", suggestion)
+		}
+		return nil
+	}
+
+	fs := flag.NewFlagSet("strength", flag.ContinueOnError)
+	minScore := fs.String("min", "", "weakest master password the vault accepts, very-weak to very-strong or 0 to 4")
+	vaultName, keyfilePath, err := parseVaultArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	score, err := parseScore(*minScore)
+	if err != nil {
+		return err
+	}
+
+	vault, _, _, err := unlockVaultCLI(vaultName, keyfilePath)
+	if err != nil {
+		return err
+	}
+	if _, err := SetMinPasswordScore(vault, score); err != nil {
+		return err
+	}
+	fmt.Printf("Vault %s now refuses master passwords weaker than %s\n", vaultName, scoreNames[score])
+	return nil
+}
+
+// parseScore reads a strength score given by name or number.
+func parseScore(value string) (int, error) {
+	if score, err := strconv.Atoi(value); err == nil && score >= 0 && score < len(scoreNames) {
+		return score, nil
+	}
+	if score := slices.Index(scoreNames, strings.ReplaceAll(value, "-", " ")); score >= 0 {
+		return score, nil
+	}
+	return 0, fmt.Errorf("unknown strength %q, use very-weak, weak, fair, strong or very-strong", value)
+}
+
+func cliKeySlots(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing slots action, see ciphery help")
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("slots "+action, flag.ContinueOnError)
+	label := fs.String("label", "", "label of the new slot")
+	slotKeyfilePath := fs.String("file", "", "keyfile for the new slot")
+	id := fs.String("id", "", "id of the slot to remove")
+	vaultName, keyfilePath, err := parseVaultArgs(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	if action == "list" {
+		vault, err := LoadVault(vaultName)
+		if err != nil {
+			return err
+		}
+		for _, slot := range vault.KeySlots {
+			created := ""
+			if !slot.CreatedAt.IsZero() {
+				created = slot.CreatedAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%-10s %-9s %-16s %s\n", slot.ID, slot.Type, created, slot.Label)
+		}
+		return nil
+	}
+
+	vault, vaultKey, _, err := unlockVaultCLI(vaultName, keyfilePath)
+	if err != nil {
+		return err
+	}
+
+	var slot KeySlot
+	switch action {
+	case "add-password":
+		newPassword, err := readPassword("Password for the new slot: ")
+		if err != nil {
+			return err
+		}
+		reNewPassword, err := readPassword("Re-enter password: ")
+		if err != nil {
+			return err
+		}
+		if ok, errMsg := MasterPasswordValidation(newPassword, reNewPassword); !ok {
+			return errors.New(errMsg)
+		}
+		if ok, errMsg := PasswordStrengthValidation(newPassword, vault.MinPasswordScore); !ok {
+			return errors.New(errMsg)
+		}
+		slot, err = NewKeySlot(slotPassword, *label, []byte(normalizePassword(newPassword)), vaultKey.Bytes(), vault.CipherName())
+		if err != nil {
+			return err
+		}
+	case "add-keyfile":
+		if *slotKeyfilePath == "" {
+			return fmt.Errorf("-file is required")
+		}
+		keyfile, err := ReadKeyfile(*slotKeyfilePath)
+		if err != nil {
+			return err
+		}
+		slot, err = NewKeySlot(slotKeyfile, *label, keyfile, vaultKey.Bytes(), vault.CipherName())
+		if err != nil {
+			return err
+		}
+	case "recovery-codes":
+		_, codes, err := GenerateRecoveryCodes(vault, vaultKey)
+		if err != nil {
+			return err
+		}
+		fmt.Println("New recovery codes, the old ones no longer work. Write them down now:")
+		for _, code := range codes {
+			fmt.Println(code)
+		}
+		return nil
+	case "remove":
+		if *id == "" {
+			return fmt.Errorf("-id is required")
+		}
+		if _, err := RemoveKeySlot(vault, *id); err != nil {
+			return err
+		}
+		fmt.Printf("Removed key slot %s\n", *id)
+		return nil
+	default:
+		return fmt.Errorf("unknown slots action %q", action)
+	}
+
+	if _, err := AddKeySlot(vault, slot); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s slot %s\n", slot.Type, slot.ID)
+	return nil
+}
+
+func cliGenerateKeyfile(args []string) error {
+	fs := flag.NewFlagSet("keyfile", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	if path == "" {
+		return fmt.Errorf("no keyfile path given")
+	}
+
+	if err := GenerateKeyfile(path); err != nil {
+		return err
+	}
+	fmt.Printf("Keyfile written to %s, keep it somewhere safe\n", path)
+	return nil
+}
+
+func cliGenerate(args []string) error {
+	options := DefaultGeneratorOptions()
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	passphrase := fs.Bool("passphrase", false, "generate a passphrase of random words")
+	fs.IntVar(&options.Length, "length", options.Length, "number of characters")
+	noLower := fs.Bool("no-lower", false, "leave out lowercase letters")
+	noUpper := fs.Bool("no-upper", false, "leave out uppercase letters")
+	noDigits := fs.Bool("no-digits", false, "leave out digits")
+	noSymbols := fs.Bool("no-symbols", false, "leave out symbols")
+	fs.BoolVar(&options.ExcludeAmbiguous, "no-ambiguous", false, "leave out characters that look alike, like 0 and O")
+	anyClass := fs.Bool("any-class", false, "don't require a character of every class")
+	fs.IntVar(&options.Words, "words", options.Words, "number of passphrase words")
+	fs.StringVar(&options.Separator, "separator", options.Separator, "text between passphrase words")
+	caps := fs.String("caps", capitalizationNames[capitalizeNone], "capitalization of passphrase words: lowercase, capitalized, uppercase or random")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *passphrase {
+		options.Mode = generatePassphrase
+	}
+	options.Lower = !*noLower
+	options.Upper = !*noUpper
+	options.Digits = !*noDigits
+	options.Symbols = !*noSymbols
+	options.RequireEach = !*anyClass
+	options.Capitalization = slices.IndexFunc(capitalizationNames, func(name string) bool {
+		return strings.EqualFold(name, *caps)
+	})
+	if options.Capitalization < 0 {
+		return fmt.Errorf("unknown capitalization %q, use lowercase, capitalized, uppercase or random", *caps)
+	}
+
+	generated, bits, err := Generate(options)
+	if err != nil {
+		return err
+	}
+	fmt.Println(generated)
+	fmt.Fprintf(os.Stderr, "About %.0f bits of entropy, %s\n", bits, scoreNames[scoreForBits(bits)])
+	return nil
+}
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// readPassword prompts for a password without echoing it. When stdin isn't a
+// terminal the password is read as a plain line so it can be piped in.
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if term.IsTerminal(os.Stdin.Fd()) {
+		password, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// askYesNo prompts for a yes or no answer, anything but y or yes is a no.
+func askYesNo(prompt string) (bool, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/aymanbagabas/go-osc52/v2"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Copied secrets are cleared from the clipboard after a while, but only if
+// nothing else was copied over them in the meantime. The timeout is a setting
+// of each vault like the auto lock one, vaults without one use
+// defaultClipboardClear, which the CIPHERY_CLIPBOARD_CLEAR environment
+// variable changes.
+//
+// The system clipboard is used when there is one. Over SSH, or without
+// clipboard tools, the text goes to the terminal as an OSC52 escape sequence
+// instead. Terminals can't be asked what their clipboard holds, so an OSC52
+// copy is always cleared.
+var defaultClipboardClear = 30 * time.Second
+
+func init() {
+	value := os.Getenv("CIPHERY_CLIPBOARD_CLEAR")
+	if value == "" {
+		return
+	}
+	timeout, err := parseTimeout(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring CIPHERY_CLIPBOARD_CLEAR: %v\n", err)
+		return
+	}
+	defaultClipboardClear = timeout
+}
+
+// ClipboardClearTimeout returns how long a copied secret of the vault stays
+// in the clipboard, zero if it isn't cleared.
+func (v Vault) ClipboardClearTimeout() time.Duration {
+	switch {
+	case v.ClipboardClearSeconds < 0:
+		return 0
+	case v.ClipboardClearSeconds > 0:
+		return time.Duration(v.ClipboardClearSeconds) * time.Second
+	default:
+		return defaultClipboardClear
+	}
+}
+
+// SetClipboardClear changes the clipboard timeout of an open vault and saves
+// it. A zero timeout uses the default, a negative one keeps copies.
+func SetClipboardClear(vault Vault, timeout time.Duration) (Vault, error) {
+	changed := vault
+	changed.ClipboardClearSeconds = int(timeout / time.Second)
+	if timeout < 0 {
+		changed.ClipboardClearSeconds = -1
+	}
+	saved, err := SaveVault(changed)
+	if err != nil {
+		return vault, err
+	}
+	return saved, nil
+}
+
+// The last copy, which the clear timer runs for. Only a hash of the copied
+// text is kept, to recognize it in the clipboard.
+var copied struct {
+	sync.Mutex
+	hash    [sha256.Size]byte
+	osc52   bool
+	what    string
+	clearAt time.Time
+	timer   *time.Timer
+}
+
+// CopyToClipboard copies text and clears it again after clearAfter, or never
+// if that's zero. what names the copied value in the countdown.
+func CopyToClipboard(text, what string, clearAfter time.Duration) error {
+	copied.Lock()
+	defer copied.Unlock()
+
+	useOSC52 := os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != ""
+	if !useOSC52 {
+		if err := clipboard.WriteAll(text); err != nil {
+			useOSC52 = true
+		}
+	}
+	if useOSC52 {
+		if err := writeOSC52(osc52.New(text)); err != nil {
+			return err
+		}
+	}
+
+	// The clipboard doesn't hold the previous copy anymore
+	forgetCopy()
+	if clearAfter > 0 {
+		copied.hash = sha256.Sum256([]byte(text))
+		copied.osc52 = useOSC52
+		copied.what = what
+		copied.clearAt = time.Now().Add(clearAfter)
+		copied.timer = time.AfterFunc(clearAfter, ClearClipboard)
+	}
+	return nil
+}
+
+// ClearClipboard clears the last copy right away if the clipboard still
+// holds it and a clear is pending. It's called when the timer runs out, the
+// vault is locked or left and the app quits.
+func ClearClipboard() {
+	copied.Lock()
+	defer copied.Unlock()
+	if copied.what == "" {
+		return
+	}
+	if copied.osc52 {
+		writeOSC52(osc52.Clear())
+	} else if current, err := clipboard.ReadAll(); err == nil {
+		hash := sha256.Sum256([]byte(current))
+		if subtle.ConstantTimeCompare(hash[:], copied.hash[:]) == 1 {
+			clipboard.WriteAll("")
+		}
+	}
+
+	forgetCopy()
+}
+
+// forgetCopy stops the clear timer. copied must be locked.
+func forgetCopy() {
+	if copied.timer != nil {
+		copied.timer.Stop()
+	}
+	copied.hash = [sha256.Size]byte{}
+	copied.osc52 = false
+	copied.what = ""
+	copied.clearAt = time.Time{}
+	copied.timer = nil
+}
+
+// ClipboardCountdown returns what was copied and how long until it's
+// cleared, or an empty name when no clear is pending.
+func ClipboardCountdown() (string, time.Duration) {
+	copied.Lock()
+	defer copied.Unlock()
+	if copied.clearAt.IsZero() {
+		return "", 0
+	}
+	return copied.what, time.Until(copied.clearAt)
+}
+
+// writeOSC52 sends the sequence to the terminal, wrapped for tmux and screen
+// when running inside them.
+func writeOSC52(sequence osc52.Sequence) error {
+	switch {
+	case os.Getenv("TMUX") != "":
+		sequence = sequence.Tmux()
+	case os.Getenv("STY") != "":
+		sequence = sequence.Screen()
+	}
+	_, err := sequence.WriteTo(os.Stderr)
+	return err
+}
+
+type ClipboardTickMsg struct {
+	generation int
+}
+
+// clipboardTick redraws the countdown every second while a clear is pending.
+// Ticks of an older generation are dropped, so there is one countdown.
+func clipboardTick(generation int) tea.Cmd {
+	if what, _ := ClipboardCountdown(); what == "" {
+		return nil
+	}
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return ClipboardTickMsg{generation: generation}
+	})
+}
+
+// startClipboardCountdown starts redrawing the countdown, replacing the one
+// running before.
+func (m *mainModel) startClipboardCountdown() tea.Cmd {
+	m.clipboardGeneration++
+	return clipboardTick(m.clipboardGeneration)
+}
+
+// clipboardNotice is the countdown shown under the secrets.
+func clipboardNotice() string {
+	what, left := ClipboardCountdown()
+	if what == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s copied, clipboard clears in %ds", what, int(math.Ceil(left.Seconds())))
+}
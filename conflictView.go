@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	lg "github.com/charmbracelet/lipgloss"
+)
+
+type ConflictModel struct {
+	keys      keyMap
+	help      help.Model
+	w, h      int
+	mainModel *mainModel
+
+	// base is the vault as the view read it, ours the change that couldn't
+	// be saved and theirs the vault as it is on disk now.
+	base, ours, theirs Vault
+	onDisk             uint64
+	decryptedVaultKey  *LockedBuffer
+
+	choices  []string
+	cursor   int
+	errorMsg string
+	// conflicts are the secrets both sides changed, shown before merging
+	conflicts []string
+}
+
+// Choices of the conflict view.
+const (
+	conflictReload = iota
+	conflictMerge
+)
+
+func InitialConflictModel(mainmdl *mainModel) ConflictModel {
+	m := ConflictModel{
+		keys:      keysConflict,
+		help:      help.New(),
+		mainModel: mainmdl,
+	}
+	return m
+}
+
+func (m ConflictModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m ConflictModel) View() string {
+	s := ""
+	s += titleStyle.Render(fmt.Sprintf("Vault %s was changed elsewhere", highlightStyle.Render(m.base.Name)))
+	s += "\n"
+	s += fmt.Sprintf("Another ciphery saved it while it was open here (revision %d, now %d).\n", m.base.Revision, m.onDisk)
+	s += "Your change wasn't saved.\n\n"
+
+	for i, choice := range m.choices {
+		if m.cursor == i {
+			s += choicesFocusedStyle.Render(fmt.Sprintf("> %s", choice))
+		} else {
+			s += choicesStyle.Render(fmt.Sprintf("  %s", choice))
+		}
+		s += "\n"
+	}
+	if !CanMergeVaults(m.base, m.ours) {
+		s += listItemDescriptionStyle.Render("Only changes to secrets can be merged.")
+		s += "\n"
+	}
+	if len(m.conflicts) > 0 {
+		s += "\nThese secrets were changed on both sides, merging keeps one change:\n"
+		s += formBorderStyle.Render(strings.Join(m.conflicts, "\n"))
+		s += "\n"
+		s += fmt.Sprintf("Press %s again to merge anyway.\n", highlightStyle.Render("enter"))
+	}
+	s += errorStyle.Render(fmt.Sprintf("%s\n", m.errorMsg))
+
+	helpView := m.help.View(m.keys)
+	s += helpStyle.Render(helpView)
+	s = lg.Place(m.w, m.h, lg.Center, lg.Center, s)
+	return s
+}
+
+func (m ConflictModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if model, cmd, ok := m.mainModel.autoLock(m, msg, m.base, false); ok {
+		return model, cmd
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		case key.Matches(msg, m.keys.Down):
+			if m.cursor < len(m.choices)-1 {
+				m.cursor++
+			}
+		case key.Matches(msg, m.keys.Up):
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case key.Matches(msg, m.keys.Enter):
+			if m.cursor == conflictMerge {
+				return m.handleMerge()
+			}
+			return m.handleReload()
+		}
+	case SendConflictMsg:
+		m.base, m.ours, m.onDisk = msg.Base, msg.Ours, msg.OnDisk
+		m.cursor = conflictReload
+		m.choices = []string{"Reload the vault and drop your change."}
+		if CanMergeVaults(m.base, m.ours) {
+			m.choices = append(m.choices, "Merge your change into the vault as it is now.")
+		}
+		return m, nil
+	case SendDecryptedVaultKeyMsg:
+		m.decryptedVaultKey = msg.VaultKey
+		return m, nil
+	case tea.WindowSizeMsg:
+		m.w = msg.Width
+		m.h = msg.Height
+		m.help.Width = msg.Width
+	}
+	return m, nil
+}
+
+// reload reads the vault as the other process left it.
+func (m ConflictModel) reload() (ConflictModel, error) {
+	theirs, err := ReloadVault(m.base, m.decryptedVaultKey)
+	if errors.Is(err, ErrVaultTampered) {
+		return m, err
+	} else if err != nil {
+		// Most likely the key was rotated or the vault sealed elsewhere
+		return m, fmt.Errorf("can't read it with the key it was opened with, lock it (ctrl+l) and unlock it again: %w", err)
+	}
+	m.theirs = theirs
+	return m, nil
+}
+
+func (m ConflictModel) handleReload() (tea.Model, tea.Cmd) {
+	m, err := m.reload()
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error reloading vault: %v", err)
+		return m, nil
+	}
+	return m.leave(m.theirs, "Vault reloaded, your change was dropped")
+}
+
+func (m ConflictModel) handleMerge() (tea.Model, tea.Cmd) {
+	m, err := m.reload()
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error reloading vault: %v", err)
+		return m, nil
+	}
+	merged, conflicts := MergeVaultSecrets(m.base, m.ours, m.theirs)
+	// Nothing is saved before the user saw what the merge drops, again if
+	// the vault changed since
+	if described := m.describeConflicts(conflicts); len(described) > 0 && !slices.Equal(described, m.conflicts) {
+		m.conflicts = described
+		m.errorMsg = ""
+		return m, nil
+	}
+
+	merged, err = SaveVault(merged)
+	var changed *VaultChangedError
+	if errors.As(err, &changed) {
+		// Saved again in the meantime, the next merge starts from there
+		m.onDisk = changed.OnDisk
+		m.conflicts = nil
+		m.errorMsg = "The vault changed again, try once more."
+		return m, nil
+	} else if err != nil {
+		m.errorMsg = fmt.Sprintf("Error merging: %v", err)
+		return m, nil
+	}
+	return m.leave(merged, "Your change was merged")
+}
+
+// describeConflicts tells for each conflict which change a merge keeps.
+func (m ConflictModel) describeConflicts(conflicts []MergeConflict) []string {
+	described := []string{}
+	for _, conflict := range conflicts {
+		// Only the name is shown, the text is wiped right away
+		name, text, err := RevealSecret(m.theirs, conflict.Secret, m.decryptedVaultKey.Bytes())
+		text.Destroy()
+		if err != nil {
+			name = "secret " + conflict.Secret.ID
+		}
+		switch conflict.Kind {
+		case conflictBothEdited:
+			described = append(described, fmt.Sprintf("%s: edited here and elsewhere, your edit is kept", name))
+		case conflictDeletedHere:
+			described = append(described, fmt.Sprintf("%s: deleted here but edited elsewhere, it is deleted", name))
+		case conflictDeletedThere:
+			described = append(described, fmt.Sprintf("%s: edited here but deleted elsewhere, your edit is kept", name))
+		}
+	}
+	return described
+}
+
+func (m ConflictModel) leave(vault Vault, confirmation string) (tea.Model, tea.Cmd) {
+	m.mainModel.conflictView = InitialConflictModel(m.mainModel)
+	m.mainModel.viewState = vaultView
+	return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey), SendConfirmationCmd(confirmation))
+}
+
+// Handing a save that ran into a newer vault file to the conflict view.
+type SendConflictMsg struct {
+	Base, Ours Vault
+	OnDisk     uint64
+}
+
+// vaultConflict opens the conflict view when err says the vault was saved by
+// another process since base was read. If it reports true, Update returns the
+// model and command it returned.
+func (m *mainModel) vaultConflict(err error, base Vault, decryptedVaultKey *LockedBuffer) (tea.Model, tea.Cmd, bool) {
+	var changed *VaultChangedError
+	if !errors.As(err, &changed) {
+		return nil, nil, false
+	}
+	conflict := SendConflictMsg{Base: base, Ours: changed.Unsaved, OnDisk: changed.OnDisk}
+	m.viewState = conflictView
+	return m.conflictView, tea.Batch(tea.WindowSize(), func() tea.Msg { return conflict }, SendDecryptedVaultKeyCmd(decryptedVaultKey)), true
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+	"slices"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/help"
@@ -23,8 +22,15 @@ type CreateSecretModel struct {
 	focusIndex int
 	inputs     []textinput.Model
 
-	decryptedVaultKey []byte
+	decryptedVaultKey *LockedBuffer
 	vault             Vault
+	strength          PasswordStrength
+	// Entropy of a generated secret text, until it gets edited
+	generatedBits float64
+	sensitive     bool
+	// editing is the index of the secret the form edits, -1 when it creates
+	// a new one.
+	editing int
 }
 
 const (
@@ -37,7 +43,8 @@ func InitialCreateSecretModel(mainmdl *mainModel) CreateSecretModel {
 		keys:      keysCreateSecret,
 		help:      help.New(),
 		mainModel: mainmdl,
-		inputs:    make([]textinput.Model, 2)}
+		inputs:    make([]textinput.Model, 2),
+		editing:   -1}
 
 	var t textinput.Model
 	for i := range m.inputs {
@@ -68,7 +75,11 @@ func (m CreateSecretModel) Init() tea.Cmd {
 
 func (m CreateSecretModel) View() string {
 	s := ""
-	s += titleStyle.Render("Create new secret")
+	title, action := "Create new secret", "create secret"
+	if m.editing >= 0 {
+		title, action = "Edit secret", "save changes"
+	}
+	s += titleStyle.Render(title)
 	s += "\n"
 
 	var b strings.Builder
@@ -81,7 +92,18 @@ func (m CreateSecretModel) View() string {
 	}
 	s += formBorderStyle.Render(b.String())
 	s += "\n"
-	s += fmt.Sprintf("Press %s to create secret. \n", highlightStyle.Render("enter"))
+	if m.generatedBits > 0 {
+		s += listItemDescriptionStyle.Render(fmt.Sprintf("Generated with about %.0f bits of entropy", m.generatedBits))
+		s += "\n"
+	} else {
+		s += strengthMeter(m.strength)
+	}
+	sensitive := "no"
+	if m.sensitive {
+		sensitive = "yes"
+	}
+	s += fmt.Sprintf("Sensitive: %s (%s to change)\n", highlightStyle.Render(sensitive), highlightStyle.Render("ctrl+s"))
+	s += fmt.Sprintf("Press %s to %s. \n", highlightStyle.Render("enter"), action)
 	s += errorStyle.Render(fmt.Sprintf("%s\n", m.errorMsg))
 
 	helpView := m.help.View(m.keys)
@@ -91,7 +113,16 @@ func (m CreateSecretModel) View() string {
 }
 
 func (m CreateSecretModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if model, cmd, ok := m.mainModel.autoLock(m, msg, m.vault, false); ok {
+		return model, cmd
+	}
+
 	switch msg := msg.(type) {
+	case StrengthTickMsg:
+		if msg.generation == m.mainModel.strengthGeneration {
+			m.strength = EstimateStrength(m.inputs[secretText].Value())
+		}
+		return m, nil
 	case tea.KeyMsg:
 		switch {
 		case key.Matches(msg, m.keys.Quit):
@@ -99,10 +130,21 @@ func (m CreateSecretModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.Help):
 			m.help.ShowAll = !m.help.ShowAll
 		case key.Matches(msg, m.keys.Back):
+			m.mainModel.createSecretView = InitialCreateSecretModel(m.mainModel)
 			m.mainModel.viewState = vaultView
 			return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey))
 		case key.Matches(msg, m.keys.Enter):
+			if m.editing >= 0 {
+				return m.handleEdit()
+			}
 			return m.handleCreate()
+		case key.Matches(msg, m.keys.ToggleSensitive):
+			m.sensitive = !m.sensitive
+			return m, nil
+		case key.Matches(msg, m.keys.Generate):
+			m.mainModel.createSecretView = m
+			m.mainModel.viewState = generatorView
+			return m.mainModel.generatorView, tea.Batch(tea.WindowSize(), SendVaultCmd(m.vault))
 		case key.Matches(msg, m.keys.Up) || key.Matches(msg, m.keys.Down):
 			// Cycle indexes
 			if key.Matches(msg, m.keys.Up) {
@@ -117,29 +159,27 @@ func (m CreateSecretModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.focusIndex = len(m.inputs) - 1
 			}
 
-			cmds := make([]tea.Cmd, len(m.inputs))
-			for i := 0; i < len(m.inputs); i++ {
-				if i == m.focusIndex {
-					// Set focused state
-					cmds[i] = m.inputs[i].Focus()
-					m.inputs[i].PromptStyle = focusedStyle
-					m.inputs[i].TextStyle = focusedStyle
-					continue
-				}
-				// Remove focused state
-				m.inputs[i].Blur()
-				m.inputs[i].PromptStyle = noStyle
-				m.inputs[i].TextStyle = noStyle
-			}
-
-			return m, tea.Batch(cmds...)
+			return m, m.focusInputs()
 		}
 	case SendDecryptedVaultKeyMsg:
-		m.decryptedVaultKey = msg
+		m.decryptedVaultKey = msg.VaultKey
 		return m, nil
 	case SendVaultMsg:
 		m.vault = msg.VaultSended
 		return m, nil
+	case SendEditSecretMsg:
+		m.editing = msg.Index
+		m.inputs[secretName].SetValue(msg.Name)
+		m.inputs[secretText].SetValue(msg.Text)
+		m.sensitive = msg.Sensitive
+		m.strength = EstimateStrength(msg.Text)
+		return m, nil
+	case SendGeneratedMsg:
+		m.inputs[secretText].SetValue(msg.Text)
+		m.strength = EstimateStrength(msg.Text)
+		m.generatedBits = msg.Bits
+		m.focusIndex = secretText
+		return m, m.focusInputs()
 	case tea.WindowSizeMsg:
 		m.w = msg.Width
 		m.h = msg.Height
@@ -147,10 +187,34 @@ func (m CreateSecretModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	// Handle character input and blinking
+	typed := m.inputs[secretText].Value()
 	cmd := m.updateInputs(msg)
+	if m.inputs[secretText].Value() != typed {
+		cmd = tea.Batch(cmd, m.mainModel.strengthTick())
+		m.generatedBits = 0
+	}
 	return m, cmd
 }
 
+// focusInputs focuses the input at focusIndex and blurs the others.
+func (m *CreateSecretModel) focusInputs() tea.Cmd {
+	cmds := make([]tea.Cmd, len(m.inputs))
+	for i := 0; i < len(m.inputs); i++ {
+		if i == m.focusIndex {
+			// Set focused state
+			cmds[i] = m.inputs[i].Focus()
+			m.inputs[i].PromptStyle = focusedStyle
+			m.inputs[i].TextStyle = focusedStyle
+			continue
+		}
+		// Remove focused state
+		m.inputs[i].Blur()
+		m.inputs[i].PromptStyle = noStyle
+		m.inputs[i].TextStyle = noStyle
+	}
+	return tea.Batch(cmds...)
+}
+
 func (m *CreateSecretModel) updateInputs(msg tea.Msg) tea.Cmd {
 	cmds := make([]tea.Cmd, len(m.inputs))
 
@@ -164,47 +228,95 @@ func (m *CreateSecretModel) updateInputs(msg tea.Msg) tea.Cmd {
 }
 
 func (m CreateSecretModel) handleCreate() (tea.Model, tea.Cmd) {
-	// Check for empty fields
-	for i := range m.inputs {
-		if len(m.inputs[i].Value()) == 0 {
-			m.errorMsg = fmt.Sprintf("[%s] option can't be empty!", m.inputs[i].Placeholder)
-			return m, nil
-		}
+	if ok, errMsg := m.validate(); !ok {
+		m.errorMsg = errMsg
+		return m, nil
 	}
-	// Check for special characters
-	if strings.ContainsAny(m.inputs[secretName].Value(), "/\\") {
-		m.errorMsg = fmt.Sprintf("[%s] option can't contain special characters!", m.inputs[secretName].Placeholder)
+
+	// Encrypt the data
+	secretID, err := newSecretID()
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error creating secret: %v", err)
 		return m, nil
 	}
-	if strings.ContainsAny(m.inputs[secretText].Value(), "/\\") {
-		m.errorMsg = fmt.Sprintf("[%s] option can't contain special characters!", m.inputs[secretText].Placeholder)
+	newSecret, err := EncryptSecret(m.vault, secretID, m.inputs[secretName].Value(), m.inputs[secretText].Value(), m.decryptedVaultKey.Bytes())
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error creating secret: %v", err)
 		return m, nil
 	}
 
-	// Encrypt the data
-	cryptedName, cryptedText := EncryptSecretData(m.inputs[secretName].Value(), m.inputs[secretText].Value(), m.decryptedVaultKey)
-	newSecret := Secret{
-		EncodedEncryptedName: cryptedName,
-		EncodedEncryptedText: cryptedText,
-	}
+	newSecret.Sensitive = m.sensitive
 
 	// Append new secret
-	m.vault.Secrets = append(m.vault.Secrets, newSecret)
+	created := m.vault
+	created.Secrets = append(slices.Clone(m.vault.Secrets), newSecret)
 
 	// Write the json
-	updateVaultByte, err := json.Marshal(m.vault)
-	if err != nil {
+	saved, err := SaveVault(created)
+	if model, cmd, ok := m.mainModel.vaultConflict(err, m.vault, m.decryptedVaultKey); ok {
+		m.mainModel.createSecretView = InitialCreateSecretModel(m.mainModel)
+		return model, cmd
+	} else if err != nil {
 		m.errorMsg = fmt.Sprintf("Error creating secret: %v", err)
 		return m, nil
 	}
-	err = os.WriteFile(fmt.Sprintf("%s%s.json", VAULTSPATH, m.vault.Name), updateVaultByte, 0644)
-	if err != nil {
-		m.errorMsg = fmt.Sprintf("Error creating secret: %v", err)
+	m.vault = saved
+
+	// Reset the view
+	m.mainModel.createSecretView = InitialCreateSecretModel(m.mainModel)
+
+	return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey))
+}
+
+// validate checks the form before a secret is created or edited.
+func (m CreateSecretModel) validate() (bool, string) {
+	// Check for empty fields
+	for i := range m.inputs {
+		if len(m.inputs[i].Value()) == 0 {
+			return false, fmt.Sprintf("[%s] option can't be empty!", m.inputs[i].Placeholder)
+		}
+	}
+	// Check for special characters
+	for _, i := range []int{secretName, secretText} {
+		if strings.ContainsAny(m.inputs[i].Value(), "/\\") {
+			return false, fmt.Sprintf("[%s] option can't contain special characters!", m.inputs[i].Placeholder)
+		}
+	}
+	return true, ""
+}
+
+func (m CreateSecretModel) handleEdit() (tea.Model, tea.Cmd) {
+	if ok, errMsg := m.validate(); !ok {
+		m.errorMsg = errMsg
+		return m, nil
+	}
+
+	vault, err := EditSecret(m.vault, m.editing, m.inputs[secretName].Value(), m.inputs[secretText].Value(), m.sensitive)
+	if model, cmd, ok := m.mainModel.vaultConflict(err, m.vault, m.decryptedVaultKey); ok {
+		m.mainModel.createSecretView = InitialCreateSecretModel(m.mainModel)
+		return model, cmd
+	} else if err != nil {
+		m.errorMsg = fmt.Sprintf("Error editing secret: %v", err)
 		return m, nil
 	}
 
 	// Reset the view
 	m.mainModel.createSecretView = InitialCreateSecretModel(m.mainModel)
 
-	return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey))
+	m.mainModel.viewState = vaultView
+	return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey), SendConfirmationCmd("Secret edited successfully"))
+}
+
+// Opening the create secret form pre-filled to edit a secret.
+type SendEditSecretMsg struct {
+	Index     int
+	Name      string
+	Text      string
+	Sensitive bool
+}
+
+func SendEditSecretCmd(index int, name, text string, sensitive bool) tea.Cmd {
+	return func() tea.Msg {
+		return SendEditSecretMsg{Index: index, Name: name, Text: text, Sensitive: sensitive}
+	}
 }
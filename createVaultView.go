@@ -1,11 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
@@ -23,6 +24,10 @@ type CreateVaultModel struct {
 	errorMsg   string
 	focusIndex int
 	inputs     []textinput.Model
+	sealMode   int
+	cipher     int // index into ciphers
+	minScore   int
+	strength   PasswordStrength
 }
 
 const (
@@ -30,14 +35,29 @@ const (
 	description
 	password
 	rePassword
+	vaultKeyfile
 )
 
+// How a new vault is stored, cycled through in the form.
+const (
+	sealModePlain = iota
+	sealModeSealed
+	sealModePadded
+)
+
+var sealModeNames = []string{
+	sealModePlain:  "plain",
+	sealModeSealed: "sealed",
+	sealModePadded: "sealed and padded",
+}
+
 func InitialCreateVaultModel(mainMdl *mainModel) CreateVaultModel {
 	m := CreateVaultModel{
 		keys:      keysCreateVault,
 		help:      help.New(),
 		mainModel: mainMdl,
-		inputs:    make([]textinput.Model, 4)}
+		inputs:    make([]textinput.Model, 5),
+		minScore:  defaultMinPasswordScore}
 
 	var t textinput.Model
 	for i := range m.inputs {
@@ -57,13 +77,14 @@ func InitialCreateVaultModel(mainMdl *mainModel) CreateVaultModel {
 		case password:
 			t.Placeholder = "Password"
 			t.EchoMode = textinput.EchoPassword
-			t.CharLimit = 32
 			t.EchoCharacter = '•'
 		case rePassword:
 			t.Placeholder = "Re-enter password"
 			t.EchoMode = textinput.EchoPassword
-			t.CharLimit = 32
 			t.EchoCharacter = '•'
+		case vaultKeyfile:
+			t.Placeholder = "Require keyfile (optional path)"
+			t.CharLimit = 256
 		}
 
 		m.inputs[i] = t
@@ -95,6 +116,14 @@ func (m CreateVaultModel) View() string {
 	}
 	s += formBorderStyle.Render(b.String())
 	s += "\n"
+	s += strengthMeter(m.strength)
+	s += fmt.Sprintf("Required strength: %s (%s to change)\n", highlightStyle.Render(scoreNames[m.minScore]), highlightStyle.Render("ctrl+r"))
+	s += fmt.Sprintf("Format: %s (%s to change)\n", highlightStyle.Render(sealModeNames[m.sealMode]), highlightStyle.Render("tab"))
+	s += fmt.Sprintf("Cipher: %s (%s to change)\n", highlightStyle.Render(ciphers[m.cipher]), highlightStyle.Render("ctrl+t"))
+	if m.sealMode != sealModePlain {
+		s += listItemDescriptionStyle.Render("Name, description and secrets are hidden until the vault is unlocked.")
+		s += "\n"
+	}
 	s += fmt.Sprintf("Press %s to create vault.\n", highlightStyle.Render("enter"))
 	s += errorStyle.Render(fmt.Sprintf("%s\n", m.errorMsg))
 
@@ -106,6 +135,11 @@ func (m CreateVaultModel) View() string {
 
 func (m CreateVaultModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case StrengthTickMsg:
+		if msg.generation == m.mainModel.strengthGeneration {
+			m.strength = EstimateStrength(normalizePassword(m.inputs[password].Value()))
+		}
+		return m, nil
 	case tea.KeyMsg:
 		switch {
 		case key.Matches(msg, m.keys.Quit):
@@ -120,6 +154,18 @@ func (m CreateVaultModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// Create vault
 			return m.handleCreate()
 
+		case key.Matches(msg, m.keys.ToggleSeal):
+			m.sealMode = (m.sealMode + 1) % len(sealModeNames)
+			return m, nil
+
+		case key.Matches(msg, m.keys.ToggleCipher):
+			m.cipher = (m.cipher + 1) % len(ciphers)
+			return m, nil
+
+		case key.Matches(msg, m.keys.ToggleMinStrength):
+			m.minScore = (m.minScore + 1) % len(scoreNames)
+			return m, nil
+
 		case key.Matches(msg, m.keys.Up) || key.Matches(msg, m.keys.Down):
 			// Cycle indexes
 			if key.Matches(msg, m.keys.Up) {
@@ -159,7 +205,11 @@ func (m CreateVaultModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	// Handle character input and blinking
+	typed := m.inputs[password].Value()
 	cmd := m.updateInputs(msg)
+	if m.inputs[password].Value() != typed {
+		cmd = tea.Batch(cmd, m.mainModel.strengthTick())
+	}
 	return m, cmd
 }
 
@@ -176,18 +226,62 @@ func (m *CreateVaultModel) updateInputs(msg tea.Msg) tea.Cmd {
 }
 
 type Vault struct {
-	Name                     string   `json:"Name"`
-	Description              string   `json:"Description"`
-	EncodedEncryptedVaultKey string   `json:"EncodedEncryptedVaultKey"`
-	EncodedSalt              string   `json:"EncodedSalt"`
-	EncodedNonce             string   `json:"EncodedNonce"`
-	Secrets                  []Secret `json:"Secrets"`
+	FormatVersion int       `json:"FormatVersion"`
+	ID            string    `json:"ID,omitempty"`
+	Cipher        string    `json:"Cipher,omitempty"` // cipher new ciphertexts are made with
+	Name          string    `json:"Name"`
+	Description   string    `json:"Description"`
+	KeySlots      []KeySlot `json:"KeySlots"`
+	Secrets       []Secret  `json:"Secrets"`
+
+	// Single wrapped vault key of vaults created before key slots. Moved
+	// into KeySlots when the vault is read.
+	EncodedEncryptedVaultKey string     `json:"EncodedEncryptedVaultKey,omitempty"`
+	EncodedSalt              string     `json:"EncodedSalt,omitempty"`
+	EncodedNonce             string     `json:"EncodedNonce,omitempty"`
+	KDF                      *KDFParams `json:"KDF,omitempty"`
+
+	// Sealed vaults keep their name, description and secrets in one
+	// encrypted payload, see sealedVault.go.
+	Sealed               bool   `json:"Sealed,omitempty"`
+	SealPadding          bool   `json:"SealPadding,omitempty"`
+	SealedCipher         string `json:"SealedCipher,omitempty"`
+	EncodedSealedPayload string `json:"EncodedSealedPayload,omitempty"`
+	EncodedSealedNonce   string `json:"EncodedSealedNonce,omitempty"`
+
+	// Seconds the vault stays open without use, 0 for the default and
+	// negative to never lock on its own.
+	AutoLockSeconds int `json:"AutoLockSeconds,omitempty"`
+	// Seconds before a copied secret is cleared from the clipboard, the
+	// same way.
+	ClipboardClearSeconds int `json:"ClipboardClearSeconds,omitempty"`
+	// Master passwords weaker than this score are refused, see
+	// passwordStrength.go.
+	MinPasswordScore int `json:"MinPasswordScore,omitempty"`
+	// Asks for the master password before a sensitive secret is revealed
+	// or copied.
+	ConfirmSensitive bool `json:"ConfirmSensitive,omitempty"`
+
+	// Incremented on every save. MAC authenticates the whole file, see
+	// vaultIntegrity.go.
+	Revision uint64 `json:"Revision,omitempty"`
+	MAC      string `json:"MAC,omitempty"`
+
+	// fileName is the file the vault was read from and vaultKey the key it
+	// was opened with, needed to save it. Neither is stored.
+	fileName string
+	vaultKey *LockedBuffer
 }
 
 type Secret struct {
+	ID     string `json:"ID,omitempty"`
+	Cipher string `json:"Cipher,omitempty"`
 	// array contains encryptedEncoded plaintext, and encodedNonce
 	EncodedEncryptedText [2]string // password or any secret data
 	EncodedEncryptedName [2]string // name of the secret
+	// Sensitive secrets may need the master password again to be revealed,
+	// see reveal.go.
+	Sensitive bool `json:"Sensitive,omitempty"`
 }
 
 func (m CreateVaultModel) handleCreate() (tea.Model, tea.Cmd) {
@@ -196,31 +290,63 @@ func (m CreateVaultModel) handleCreate() (tea.Model, tea.Cmd) {
 		m.errorMsg = errMsg
 		return m, nil
 	}
+	if ok, errMsg := PasswordStrengthValidation(m.inputs[password].Value(), m.minScore); !ok {
+		m.errorMsg = errMsg
+		return m, nil
+	}
 
-	key, salt, nonce := CreateAndEncryptVaultKey(m.inputs[3].Value())
-
-	newVault := Vault{
-		Name:                     m.inputs[name].Value(),
-		Description:              m.inputs[description].Value(),
-		EncodedEncryptedVaultKey: key,
-		EncodedSalt:              salt,
-		EncodedNonce:             nonce,
-		Secrets:                  make([]Secret, 0),
+	vaultKey, err := generateVaultKey()
+	if err != nil {
+		log.Fatal(err)
+		os.Exit(1)
+	}
+	creds, err := NewCredentials(m.inputs[password].Value(), m.inputs[vaultKeyfile].Value())
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error reading keyfile: %v", err)
+		return m, nil
+	}
+	passwordSlot := KeySlot{Type: slotPassword, Keyfile: creds.Keyfile != nil}
+	slot, err := NewKeySlot(slotPassword, "master password", creds.secretFor(passwordSlot), vaultKey.Bytes(), ciphers[m.cipher])
+	if err != nil {
+		log.Fatal(err)
+		os.Exit(1)
 	}
+	slot.Keyfile = passwordSlot.Keyfile
 
-	newVaultByte, err := json.Marshal(newVault)
+	vaultID, err := newVaultID()
 	if err != nil {
 		log.Fatal(err)
 		os.Exit(1)
 	}
-	err = os.WriteFile(fmt.Sprintf("%s%s.json", VAULTSPATH, newVault.Name), newVaultByte, 0644)
+
+	newVault := Vault{
+		FormatVersion:    currentFormatVersion,
+		ID:               vaultID,
+		Cipher:           ciphers[m.cipher],
+		Name:             m.inputs[name].Value(),
+		Description:      m.inputs[description].Value(),
+		KeySlots:         []KeySlot{slot},
+		Secrets:          make([]Secret, 0),
+		Sealed:           m.sealMode != sealModePlain,
+		SealPadding:      m.sealMode == sealModePadded,
+		MinPasswordScore: m.minScore,
+		vaultKey:         vaultKey,
+	}
+
+	newVault, err = SaveVault(newVault)
 	if err != nil {
 		log.Fatal(err)
 		os.Exit(1)
 	}
 
-	m.mainModel.viewState = homeView
-	return m.mainModel, tea.WindowSize()
+	newVault, codes, err := GenerateRecoveryCodes(newVault, vaultKey)
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Vault created but generating recovery codes failed: %v", err)
+		return m, nil
+	}
+
+	m.mainModel.viewState = recoveryCodesView
+	return m.mainModel.recoveryCodesView, tea.Batch(tea.WindowSize(), SendVaultCmd(newVault), SendRecoveryCodesCmd(codes, homeView))
 }
 
 func CreateVaultValidation(inputs []textinput.Model) (bool, string) {
@@ -229,36 +355,106 @@ func CreateVaultValidation(inputs []textinput.Model) (bool, string) {
 	// Vault name cant contain spaces or special characters it's going to be used as a file name
 	errorMsg := ""
 	for i := range inputs {
-		if len(inputs[i].Value()) == 0 {
+		if i != vaultKeyfile && len(inputs[i].Value()) == 0 {
 			errorMsg = fmt.Sprintf("[%s] option can't be empty!", inputs[i].Placeholder)
 			return false, errorMsg
 		}
 	}
 
-	//  Trim whitespace from the beginning and end of input
+	//  Trim whitespace from the beginning and end of input, passwords are
+	// taken as they are
 	for i := range inputs {
-		inputs[i].SetValue(strings.TrimSpace(inputs[i].Value()))
+		if i != password && i != rePassword {
+			inputs[i].SetValue(strings.TrimSpace(inputs[i].Value()))
+		}
 	}
 
-	if _, err := os.Stat(fmt.Sprintf("%s%s.json", VAULTSPATH, inputs[name].Value())); err == nil {
+	if _, err := store.Load(inputs[name].Value()); err == nil {
 		errorMsg = "Vault with that name already exists!"
-	} else if len(inputs[password].Value()) < 8 {
-		errorMsg = "Password must be at least 8 characters long!"
 	} else if strings.ContainsAny(inputs[name].Value(), "/\\") {
 		errorMsg = "Vault name can't contain special characters!"
 	} else if strings.ContainsAny(inputs[name].Value(), " ") {
 		errorMsg = "Vault name can't contain spaces!"
 	} else if strings.ContainsAny(inputs[description].Value(), "/\\") {
 		errorMsg = "Description can't contain special characters!"
-	} else if strings.ContainsAny(inputs[password].Value(), "/\\") {
-		errorMsg = "Password can't contain special characters!"
-	} else if strings.ContainsAny(inputs[password].Value(), " ") {
-		errorMsg = "Password can't contain spaces!"
-	} else if inputs[password].Value() != inputs[rePassword].Value() {
-		errorMsg = "Passwords don't match!"
+	} else if ok, msg := MasterPasswordValidation(inputs[password].Value(), inputs[rePassword].Value()); !ok {
+		errorMsg = msg
 	} else {
 		errorMsg = ""
 		return true, errorMsg
 	}
 	return false, errorMsg
 }
+
+// PasswordStrengthValidation refuses master passwords weaker than minScore.
+func PasswordStrengthValidation(password string, minScore int) (bool, string) {
+	strength := EstimateStrength(normalizePassword(password))
+	if strength.Score >= minScore {
+		return true, ""
+	}
+	errorMsg := fmt.Sprintf("Password is %s, this vault needs at least %s!", scoreNames[strength.Score], scoreNames[minScore])
+	if strength.Warning != "" {
+		errorMsg += "\n" + strength.Warning
+	}
+	return false, errorMsg
+}
+
+// The strength meter waits until typing pauses for strengthDelay, estimating
+// a long password takes a while.
+const strengthDelay = 150 * time.Millisecond
+
+type StrengthTickMsg struct {
+	generation int
+}
+
+// strengthTick asks for the strength of what was typed once typing pauses.
+// Ticks of earlier keystrokes die off.
+func (m *mainModel) strengthTick() tea.Cmd {
+	m.strengthGeneration++
+	generation := m.strengthGeneration
+	return tea.Tick(strengthDelay, func(time.Time) tea.Msg {
+		return StrengthTickMsg{generation: generation}
+	})
+}
+
+// strengthMeter shows how strong the password being typed is, with what
+// makes it weak.
+func strengthMeter(strength PasswordStrength) string {
+	if strength.Bits == 0 {
+		return ""
+	}
+	style := errorStyle
+	switch {
+	case strength.Score >= scoreStrong:
+		style = confirmationStyle
+	case strength.Score == scoreFair:
+		style = highlightStyle
+	}
+	bar := strings.Repeat("■", strength.Score+1) + strings.Repeat("□", len(scoreNames)-strength.Score-1)
+	s := fmt.Sprintf("Strength: %s %s, about %.0f bits\n", style.Render(bar), style.Render(scoreNames[strength.Score]), strength.Bits)
+	if strength.Warning != "" {
+		s += listItemDescriptionStyle.Render(strength.Warning)
+		s += "\n"
+	}
+	for _, suggestion := range strength.Suggestions {
+		s += listItemDescriptionStyle.Render("- " + suggestion)
+		s += "\n"
+	}
+	return s
+}
+
+func MasterPasswordValidation(password, rePassword string) (bool, string) {
+	errorMsg := ""
+	// Length is counted in characters of the normalized password, which is
+	// what the key is derived from
+	if utf8.RuneCountInString(normalizePassword(password)) < 8 {
+		errorMsg = "Password must be at least 8 characters long!"
+	} else if strings.TrimSpace(password) == "" {
+		errorMsg = "Password can't be only spaces!"
+	} else if normalizePassword(password) != normalizePassword(rePassword) {
+		errorMsg = "Passwords don't match!"
+	} else {
+		return true, errorMsg
+	}
+	return false, errorMsg
+}
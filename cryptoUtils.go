@@ -6,59 +6,136 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"fmt"
 	"io"
-	"log"
-	"os"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/pbkdf2"
 )
 
-func CreateAndEncryptVaultKey(password string) (string, string, string) {
-	// Generate a salt
-	salt := make([]byte, 16)
-	_, err := rand.Read(salt)
-	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+const (
+	kdfArgon2id     = "argon2id"
+	kdfPBKDF2SHA256 = "pbkdf2-sha256"
+)
 
+// Ciphers a vault can encrypt with. Every ciphertext records the cipher it
+// was made with, an empty one means AES-256-GCM since that was the only
+// cipher before.
+const (
+	cipherAES256GCM         = "aes-256-gcm"
+	cipherXChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+var ciphers = []string{cipherAES256GCM, cipherXChaCha20Poly1305}
+
+func validCipher(cipherName string) bool {
+	for _, c := range ciphers {
+		if c == cipherName {
+			return true
+		}
 	}
+	return false
+}
 
-	// Derive the key using PBKDF2 (could also use bcrypt or Argon2)
-	key := pbkdf2.Key([]byte(password), salt, 4096, 32, sha256.New)
+// KDFParams describes how a master password is stretched into the key that
+// wraps the vault key. It's stored in the vault file so every vault unlocks
+// with the parameters it was created with.
+type KDFParams struct {
+	Algorithm   string `json:"Algorithm"`
+	Memory      uint32 `json:"Memory,omitempty"`      // argon2id memory in KiB
+	Time        uint32 `json:"Time,omitempty"`        // argon2id passes
+	Parallelism uint8  `json:"Parallelism,omitempty"` // argon2id lanes
+	Iterations  int    `json:"Iterations,omitempty"`  // pbkdf2 rounds
+}
 
-	// vaultKey for an extra layer of security
+// DefaultKDFParams are used for new vaults and for upgraded ones.
+func DefaultKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm:   kdfArgon2id,
+		Memory:      64 * 1024,
+		Time:        3,
+		Parallelism: 4,
+	}
+}
 
-	// Encrypt the vault key using AES-GCM
-	vaultKey, err := generateVaultKey()
-	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+// recoveryKDFParams are used for recovery code slots. The codes are random so
+// a light setting is plenty, and it keeps trying every code on unlock quick.
+func recoveryKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm:   kdfArgon2id,
+		Memory:      8 * 1024,
+		Time:        1,
+		Parallelism: 1,
+	}
+}
+
+// legacyKDFParams are the parameters every vault used before they were
+// recorded in the vault file.
+func legacyKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm:  kdfPBKDF2SHA256,
+		Iterations: 4096,
+	}
+}
 
+func deriveKey(password, salt []byte, params KDFParams) ([]byte, error) {
+	switch params.Algorithm {
+	case kdfArgon2id:
+		if params.Memory == 0 || params.Time == 0 || params.Parallelism == 0 {
+			return nil, fmt.Errorf("invalid argon2id parameters")
+		}
+		return argon2.IDKey(password, salt, params.Time, params.Memory, params.Parallelism, 32), nil
+	case kdfPBKDF2SHA256:
+		if params.Iterations <= 0 {
+			return nil, fmt.Errorf("invalid pbkdf2 parameters")
+		}
+		return pbkdf2.Key(password, salt, params.Iterations, 32, sha256.New), nil
 	}
-	encryptedKey, nonce, err := encryptAESGCM(vaultKey, key)
+	return nil, fmt.Errorf("unknown key derivation algorithm %q", params.Algorithm)
+}
+
+// WrapVaultKey encrypts vaultKey with a key derived from secret using a fresh
+// salt. Results are base64 encoded for safe storage in JSON.
+func WrapVaultKey(vaultKey, secret []byte, params KDFParams, cipherName string) (string, string, string, error) {
+	// Generate a salt
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
 	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+		return "", "", "", err
+	}
 
+	key, err := deriveKey(secret, salt, params)
+	if err != nil {
+		return "", "", "", err
+	}
+	defer wipeBytes(key)
+
+	encryptedKey, nonce, err := encryptWith(cipherName, vaultKey, key, nil)
+	if err != nil {
+		return "", "", "", err
 	}
 
-	// Base64 encode everything for safe storage in JSON
 	encodedEncryptedVaultKey := base64.StdEncoding.EncodeToString(encryptedKey)
 	encodedSalt := base64.StdEncoding.EncodeToString(salt)
 	encodedNonce := base64.StdEncoding.EncodeToString(nonce)
 
-	return encodedEncryptedVaultKey, encodedSalt, encodedNonce
+	return encodedEncryptedVaultKey, encodedSalt, encodedNonce, nil
 }
 
-func DecryptVaultKeyFromPassword(password, encodedSalt, encodedEncryptedVaultKey, encodedNonce string) ([]byte, bool) {
-	// check if the given master password can decrypt vaultkey. if it can't return false meaning wrong master password.
+func UnwrapVaultKey(secret []byte, encodedSalt, encodedEncryptedVaultKey, encodedNonce string, params KDFParams, cipherName string) ([]byte, bool) {
+	// check if the given secret can decrypt vaultkey. if it can't return false meaning wrong master password.
 	decodedSalt, _ := base64.StdEncoding.DecodeString(encodedSalt)
 	decodedEncryptedVaultKey, _ := base64.StdEncoding.DecodeString(encodedEncryptedVaultKey)
 	decodedNonce, _ := base64.StdEncoding.DecodeString(encodedNonce)
 
-	derivedKey := pbkdf2.Key([]byte(password), decodedSalt, 4096, 32, sha256.New)
+	derivedKey, err := deriveKey(secret, decodedSalt, params)
+	if err != nil {
+		return nil, false
+	}
+	defer wipeBytes(derivedKey)
 
-	decryptedVaultKey, err := decryptAESGCM(decodedEncryptedVaultKey, derivedKey, decodedNonce)
+	decryptedVaultKey, err := decryptWith(cipherName, decodedEncryptedVaultKey, derivedKey, decodedNonce, nil)
 	auth := true
 	if err != nil {
 		auth = false
@@ -67,29 +144,50 @@ func DecryptVaultKeyFromPassword(password, encodedSalt, encodedEncryptedVaultKey
 	return decryptedVaultKey, auth
 }
 
-func EncryptSecretData(secretName, secretText string, vaultKey []byte) ([2]string, [2]string) {
-	encryptedSecretName, nonceSecretName, err := encryptAESGCM([]byte(secretName), vaultKey)
+// EncryptSecretData encrypts the name and text of a secret. aad holds the
+// associated data each of them is authenticated with, {name, text}.
+func EncryptSecretData(secretName, secretText string, vaultKey []byte, aad [2][]byte, cipherName string) ([2]string, [2]string, error) {
+	encodedEncryptedSecretName, err := EncryptSecretField(secretName, vaultKey, aad[0], cipherName)
 	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+		return [2]string{}, [2]string{}, err
 	}
-	encryptedSecretText, nonceSecretText, err := encryptAESGCM([]byte(secretText), vaultKey)
+	encodedEncryptedSecretText, err := EncryptSecretField(secretText, vaultKey, aad[1], cipherName)
 	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
-
+		return [2]string{}, [2]string{}, err
 	}
+	return encodedEncryptedSecretName, encodedEncryptedSecretText, nil
+}
 
-	encodedEncryptedSecretName := base64.StdEncoding.EncodeToString(encryptedSecretName)
-	encodedNonceSecretName := base64.StdEncoding.EncodeToString(nonceSecretName)
-	encodedEncryptedSecretText := base64.StdEncoding.EncodeToString(encryptedSecretText)
-	encodedNonceSecretText := base64.StdEncoding.EncodeToString(nonceSecretText)
+// EncryptSecretField encrypts the name or the text of a secret under a fresh
+// nonce.
+func EncryptSecretField(plaintext string, vaultKey, aad []byte, cipherName string) ([2]string, error) {
+	return encryptSecretField([]byte(plaintext), vaultKey, aad, cipherName)
+}
+
+// encryptSecretField is EncryptSecretField for a plaintext the caller keeps
+// in locked memory.
+func encryptSecretField(plaintext, vaultKey, aad []byte, cipherName string) ([2]string, error) {
+	encrypted, nonce, err := encryptWith(cipherName, plaintext, vaultKey, aad)
+	if err != nil {
+		return [2]string{}, err
+	}
 
 	// [2]{cipher, nonce}
-	return [2]string{encodedEncryptedSecretName, encodedNonceSecretName}, [2]string{encodedEncryptedSecretText, encodedNonceSecretText}
+	return [2]string{base64.StdEncoding.EncodeToString(encrypted), base64.StdEncoding.EncodeToString(nonce)}, nil
 }
 
-func DecryptSecretData(encodedEncryptedName, encodedEncryptedText [2]string, vaultKey []byte) (string, string) {
+func DecryptSecretData(encodedEncryptedName, encodedEncryptedText [2]string, vaultKey []byte, aad [2][]byte, cipherName string) (string, string, error) {
+	secretName, secretText, err := decryptSecretFields(encodedEncryptedName, encodedEncryptedText, vaultKey, aad, cipherName)
+	if err != nil {
+		return "", "", err
+	}
+	defer wipeBytes(secretText)
+	return string(secretName), string(secretText), nil
+}
+
+// decryptSecretFields decrypts the name and text of a secret without turning
+// them into strings, so the caller can wipe them.
+func decryptSecretFields(encodedEncryptedName, encodedEncryptedText [2]string, vaultKey []byte, aad [2][]byte, cipherName string) ([]byte, []byte, error) {
 	encodedEncryptedSecretName, encodedNonceSecretName := encodedEncryptedName[0], encodedEncryptedName[1]
 	encodedEncryptedSecretText, encodedNonceSecretText := encodedEncryptedText[0], encodedEncryptedText[1]
 
@@ -98,19 +196,41 @@ func DecryptSecretData(encodedEncryptedName, encodedEncryptedText [2]string, vau
 	decodedEncryptedSecretText, _ := base64.StdEncoding.DecodeString(encodedEncryptedSecretText)
 	decodedNonceSecretText, _ := base64.StdEncoding.DecodeString(encodedNonceSecretText)
 
-	decryptedSecretName, err := decryptAESGCM(decodedEncryptedSecretName, vaultKey, decodedNonceSecretName)
+	decryptedSecretName, err := decryptWith(cipherName, decodedEncryptedSecretName, vaultKey, decodedNonceSecretName, aad[0])
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
-	decryptedSecretText, err := decryptAESGCM(decodedEncryptedSecretText, vaultKey, decodedNonceSecretText)
+	decryptedSecretText, err := decryptWith(cipherName, decodedEncryptedSecretText, vaultKey, decodedNonceSecretText, aad[1])
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 
-	return string(decryptedSecretName[:]), string(decryptedSecretText[:])
+	return decryptedSecretName, decryptedSecretText, nil
 }
 
-func encryptAESGCM(plaintext, key []byte) (ciphertext, nonce []byte, err error) {
+// encryptWith encrypts with the named cipher.
+func encryptWith(cipherName string, plaintext, key, additionalData []byte) (ciphertext, nonce []byte, err error) {
+	switch cipherName {
+	case "", cipherAES256GCM:
+		return encryptAESGCM(plaintext, key, additionalData)
+	case cipherXChaCha20Poly1305:
+		return encryptXChaCha20Poly1305(plaintext, key, additionalData)
+	}
+	return nil, nil, fmt.Errorf("unknown cipher %q", cipherName)
+}
+
+// decryptWith decrypts with the named cipher.
+func decryptWith(cipherName string, ciphertext, key, nonce, additionalData []byte) (plaintext []byte, err error) {
+	switch cipherName {
+	case "", cipherAES256GCM:
+		return decryptAESGCM(ciphertext, key, nonce, additionalData)
+	case cipherXChaCha20Poly1305:
+		return decryptXChaCha20Poly1305(ciphertext, key, nonce, additionalData)
+	}
+	return nil, fmt.Errorf("unknown cipher %q", cipherName)
+}
+
+func encryptAESGCM(plaintext, key, additionalData []byte) (ciphertext, nonce []byte, err error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, nil, err
@@ -126,10 +246,10 @@ func encryptAESGCM(plaintext, key []byte) (ciphertext, nonce []byte, err error)
 		return nil, nil, err
 	}
 
-	ciphertext = gcm.Seal(nil, nonce, plaintext, nil)
+	ciphertext = gcm.Seal(nil, nonce, plaintext, additionalData)
 	return ciphertext, nonce, nil
 }
-func decryptAESGCM(ciphertext, key, nonce []byte) (plaintext []byte, err error) {
+func decryptAESGCM(ciphertext, key, nonce, additionalData []byte) (plaintext []byte, err error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -139,16 +259,50 @@ func decryptAESGCM(ciphertext, key, nonce []byte) (plaintext []byte, err error)
 	if err != nil {
 		return nil, err
 	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size")
+	}
 
-	plaintext, err = gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err = gcm.Open(nil, nonce, ciphertext, additionalData)
 	return plaintext, err
 }
 
-func generateVaultKey() ([]byte, error) {
-	key := make([]byte, 32) // 32 bytes for AES-256
-	_, err := rand.Read(key)
+// generateVaultKey returns a new random vault key in locked memory.
+func generateVaultKey() (*LockedBuffer, error) {
+	key := NewLockedBuffer(32) // 32 bytes for AES-256
+	_, err := rand.Read(key.Bytes())
 	if err != nil {
+		key.Destroy()
 		return nil, err
 	}
 	return key, nil
 }
+
+// XChaCha20-Poly1305 is fast without AES hardware and its 192-bit nonces are
+// safe to pick at random however often a vault is encrypted again.
+func encryptXChaCha20Poly1305(plaintext, key, additionalData []byte) (ciphertext, nonce []byte, err error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+
+	ciphertext = aead.Seal(nil, nonce, plaintext, additionalData)
+	return ciphertext, nonce, nil
+}
+func decryptXChaCha20Poly1305(ciphertext, key, nonce, additionalData []byte) (plaintext []byte, err error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size")
+	}
+
+	plaintext, err = aead.Open(nil, nonce, ciphertext, additionalData)
+	return plaintext, err
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
@@ -18,6 +21,16 @@ type EnterVaultModel struct {
 	vault     Vault
 	textInput textinput.Model
 	errorMsg  string
+
+	keyfileInput textinput.Model
+	// notice tells why the vault was locked.
+	notice string
+	// waitUntil is when the next unlock attempt is allowed after too many
+	// failed ones.
+	waitUntil time.Time
+	// openAnyway is set once a tamper warning was shown, so the next enter
+	// opens the vault regardless.
+	openAnyway bool
 }
 
 func InitialEnterVaultModel(mainmdl *mainModel) EnterVaultModel {
@@ -31,29 +44,50 @@ func InitialEnterVaultModel(mainmdl *mainModel) EnterVaultModel {
 	ti := textinput.New()
 	ti.Placeholder = "Master password"
 	ti.Focus()
-	ti.CharLimit = 16
 	ti.Width = 20
 	ti.EchoMode = textinput.EchoPassword
 	ti.EchoCharacter = '•'
 
 	m.textInput = ti
+	m.keyfileInput = newKeyfileInput()
 	return m
 }
 
+// newKeyfileInput is the optional keyfile path field of the forms that
+// unlock a vault.
+func newKeyfileInput() textinput.Model {
+	t := textinput.New()
+	t.Cursor.Style = cursorStyle
+	t.Placeholder = "Keyfile path (optional)"
+	t.CharLimit = 256
+	t.Width = 20
+	return t
+}
+
 func (m EnterVaultModel) Init() tea.Cmd {
 	return textinput.Blink
 }
 
 func (m EnterVaultModel) View() string {
 	s := ""
-	s += titleStyle.Render(fmt.Sprintf("Entering vault %s", highlightStyle.Render(m.vault.Name)))
+	s += titleStyle.Render(fmt.Sprintf("Entering vault %s", highlightStyle.Render(m.vault.DisplayName())))
 	s += "\n"
 
+	if m.notice != "" {
+		s += confirmationStyle.Render(m.notice)
+		s += "\n"
+	}
 	s += focusedStyle.Render(m.textInput.View())
 	s += "\n"
+	s += m.keyfileInput.View()
+	s += "\n"
 
 	s += errorStyle.Render(m.errorMsg)
 	s += "\n"
+	if wait := time.Until(m.waitUntil); wait > 0 {
+		s += errorStyle.Render(fmt.Sprintf("Too many failed attempts, try again in %s", wait.Truncate(time.Second)+time.Second))
+		s += "\n"
+	}
 
 	helpView := m.help.View(m.keys)
 	s += helpStyle.Render(helpView)
@@ -62,7 +96,7 @@ func (m EnterVaultModel) View() string {
 }
 
 func (m EnterVaultModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	var cmd tea.Cmd
+	var cmd, throttleCmd tea.Cmd
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
@@ -74,36 +108,165 @@ func (m EnterVaultModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.Enter):
 			return m.handleEnterVault()
 		case key.Matches(msg, m.keys.Back):
+			m.openAnyway = false
 			m.mainModel.viewState = vaultsView
 			return m.mainModel.vaultsView, tea.WindowSize()
+		case key.Matches(msg, m.keys.Up) || key.Matches(msg, m.keys.Down):
+			// Switch between the password and the keyfile field
+			if m.textInput.Focused() {
+				m.textInput.Blur()
+				return m, m.keyfileInput.Focus()
+			}
+			m.keyfileInput.Blur()
+			return m, m.textInput.Focus()
 		}
 	case SendVaultMsg:
 		m.vault = msg.VaultSended
+		m.openAnyway = false
+		m.waitUntil = time.Time{}
+		m, throttleCmd = m.withUnlockWait()
+	case ThrottleTickMsg:
+		if wait := time.Until(m.waitUntil); wait > 0 {
+			throttleCmd = throttleTick(min(wait, time.Second))
+		}
+	case SendVaultLockedMsg:
+		m.notice = string(msg)
 	case tea.WindowSizeMsg:
 		m.w = msg.Width
 		m.h = msg.Height
 		m.help.Width = msg.Width
 	}
 
+	var keyfileCmd tea.Cmd
 	m.textInput, cmd = m.textInput.Update(msg)
-	return m, cmd
+	m.keyfileInput, keyfileCmd = m.keyfileInput.Update(msg)
+	return m, tea.Batch(cmd, keyfileCmd, throttleCmd)
+}
+
+type ThrottleTickMsg struct{}
+
+// throttleTick redraws the view while it counts down the wait.
+func throttleTick(after time.Duration) tea.Cmd {
+	return tea.Tick(after, func(time.Time) tea.Msg {
+		return ThrottleTickMsg{}
+	})
+}
+
+// withUnlockWait reads how long the vault has to wait before the next
+// attempt and starts counting it down.
+func (m EnterVaultModel) withUnlockWait() (EnterVaultModel, tea.Cmd) {
+	wait, err := UnlockWait(m.vault)
+	if err != nil || wait == 0 {
+		return m, nil
+	}
+	counting := time.Now().Before(m.waitUntil)
+	m.waitUntil = time.Now().Add(wait)
+	if counting {
+		return m, nil
+	}
+	return m, throttleTick(min(wait, time.Second))
 }
 
-type SendDecryptedVaultKeyMsg []byte
+// Telling the view after the unlock screen how many failed attempts there were
+// since the vault was last unlocked.
+type SendFailedAttemptsMsg int
 
-func SendDecryptedVaultKeyCmd(decryptedVaultKey []byte) tea.Cmd {
+func SendFailedAttemptsCmd(failedAttempts int) tea.Cmd {
+	if failedAttempts == 0 {
+		return nil
+	}
 	return func() tea.Msg {
-		return SendDecryptedVaultKeyMsg(decryptedVaultKey)
+		return SendFailedAttemptsMsg(failedAttempts)
+	}
+}
+
+// SendDecryptedVaultKeyMsg hands the buffer of the vault key to the next
+// view, the buffer itself so it can be destroyed.
+type SendDecryptedVaultKeyMsg struct {
+	VaultKey *LockedBuffer
+}
+
+func SendDecryptedVaultKeyCmd(decryptedVaultKey *LockedBuffer) tea.Cmd {
+	return func() tea.Msg {
+		return SendDecryptedVaultKeyMsg{VaultKey: decryptedVaultKey}
 	}
 }
 
 func (m EnterVaultModel) handleEnterVault() (tea.Model, tea.Cmd) {
-	decryptedVaultKey, auth := DecryptVaultKeyFromPassword(m.textInput.Value(), m.vault.EncodedSalt, m.vault.EncodedEncryptedVaultKey, m.vault.EncodedNonce)
-	if !auth {
+	if time.Now().Before(m.waitUntil) {
+		return m, nil
+	}
+	creds, err := NewCredentials(m.textInput.Value(), strings.TrimSpace(m.keyfileInput.Value()))
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error reading keyfile: %v", err)
+		return m, nil
+	}
+	// Read the file again, the copy from the list may be outdated
+	if vault, err := LoadVault(m.vault.FileName()); err == nil {
+		m.vault = vault
+	}
+	decryptedVaultKey, slotIndex, failedAttempts, err := ThrottledUnlock(m.vault, creds)
+	if errors.Is(err, ErrUnlockThrottled) {
+		m.errorMsg = ""
+		return m.withUnlockWait()
+	} else if errors.Is(err, ErrWrongPassword) {
 		m.errorMsg = "Wrong master password!"
+		if m.vault.RequiresKeyfile() && creds.Keyfile == nil {
+			m.errorMsg = "Wrong master password or missing keyfile!"
+		}
+		return m.withUnlockWait()
+	} else if err != nil {
+		m.errorMsg = fmt.Sprintf("Error unlocking vault: %v", err)
+		return m, nil
+	}
+
+	// Nothing in the file is trusted before it is checked as a whole
+	if err := VerifyVault(m.vault, decryptedVaultKey.Bytes()); errors.Is(err, ErrVaultTampered) {
+		if !m.openAnyway {
+			m.errorMsg = fmt.Sprintf("Warning: %v!\nPress enter again to open it anyway.", err)
+			m.openAnyway = true
+			decryptedVaultKey.Destroy()
+			return m, nil
+		}
+	} else if err != nil {
+		m.errorMsg = fmt.Sprintf("Error checking vault: %v", err)
+		decryptedVaultKey.Destroy()
 		return m, nil
 	}
+	m.openAnyway = false
+
+	// Sealed vaults only show their name and secrets once they're open
+	unsealed, err := OpenVault(m.vault, decryptedVaultKey)
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error opening sealed vault: %v", err)
+		decryptedVaultKey.Destroy()
+		return m, nil
+	}
+
+	// Files in an older format are migrated now that the key is known
+	migrated, err := MigrateVault(unsealed, decryptedVaultKey)
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error migrating vault: %v", err)
+		decryptedVaultKey.Destroy()
+		return m, nil
+	}
+	m.vault = migrated
+
+	// Slots created with older key derivation settings are upgraded in place
+	// now that we know their secret. If that fails the vault still opens.
+	slot := m.vault.KeySlots[slotIndex]
+	if slot.Type == slotRecovery {
+		// A recovery code means the password is lost, so a new one has to be
+		// set before going on.
+		m.mainModel.viewState = changePasswordView
+		return m.mainModel.changePasswordView, tea.Batch(tea.WindowSize(), textinput.Blink, SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(decryptedVaultKey), SendRecoveryCmd(slotIndex, creds.Keyfile), SendFailedAttemptsCmd(failedAttempts), m.mainModel.startAutoLock(m.vault))
+	}
+	if slot.KDF != kdfParamsFor(slot.Type) {
+		if upgraded, err := UpgradeSlotKDF(m.vault, slotIndex, creds.secretFor(slot), decryptedVaultKey); err == nil {
+			m.vault = upgraded
+		}
+	}
 
 	m.mainModel.viewState = vaultView
-	return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), m.mainModel.vaultView.Init(), SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(decryptedVaultKey))
+	return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), m.mainModel.vaultView.Init(), SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(decryptedVaultKey), SendFailedAttemptsCmd(failedAttempts), m.mainModel.startAutoLock(m.vault))
 }
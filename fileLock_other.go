@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+// There is no flock here. Saves still check the revision on disk first, only
+// without the lock two of them can race between the check and the write.
+
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive advisory lock on the file at path, creating it
+// if needed, and waits until other processes release theirs. The returned
+// function releases it.
+func lockFile(path string) (func(), error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := unix.Flock(int(file.Fd()), unix.LOCK_EX); err != nil {
+			file.Close()
+			return nil, err
+		}
+		// Whoever held the lock before may have removed the file, then the
+		// next process locks a new one and this lock keeps nobody out
+		held, err := lockedFileAt(file, path)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if held {
+			return func() {
+				unix.Flock(int(file.Fd()), unix.LOCK_UN)
+				file.Close()
+			}, nil
+		}
+		file.Close()
+	}
+}
+
+// lockedFileAt reports whether file is still the one at path.
+func lockedFileAt(file *os.File, path string) (bool, error) {
+	var locked, onDisk unix.Stat_t
+	if err := unix.Fstat(int(file.Fd()), &locked); err != nil {
+		return false, err
+	}
+	if err := unix.Stat(path, &onDisk); errors.Is(err, unix.ENOENT) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return locked.Dev == onDisk.Dev && locked.Ino == onDisk.Ino, nil
+}
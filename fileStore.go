@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore keeps every vault as <name>.json in a directory. Next to it are
+// the copy the last save replaced, <name>.bak, the copies made before
+// migrations, <name>.v<version>.bak, and the file saves lock, .<name>.lock.
+// It can't be the vault file itself since every save replaces that.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) FileStore {
+	return FileStore{dir: dir}
+}
+
+func (s FileStore) vaultPath(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+func (s FileStore) previousPath(name string) string {
+	return filepath.Join(s.dir, name+".bak")
+}
+
+func (s FileStore) backupPath(name string, version int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s.v%d.bak", name, version))
+}
+
+func (s FileStore) lockPath(name string) string {
+	return filepath.Join(s.dir, "."+name+".lock")
+}
+
+func (s FileStore) List() ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(file.Name(), ".json"))
+	}
+	return names, nil
+}
+
+func (s FileStore) Load(name string) ([]byte, error) {
+	return os.ReadFile(s.vaultPath(name))
+}
+
+// Save writes the vault through writeFileAtomic, so a failed save never
+// leaves a half written vault behind, and keeps the file it replaces unless
+// the key slots changed, see sameKeySlots. The stored revision is read under
+// the same lock as the write, so of two saves from the same revision only
+// the first one passes the check.
+func (s FileStore) Save(name string, data []byte, revision uint64) error {
+	// Other ciphery processes wait until this save is done
+	unlock, err := lockFile(s.lockPath(name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := s.Load(name)
+	if err := checkStoredRevision(current, err, revision); err != nil {
+		return err
+	}
+
+	path := s.vaultPath(name)
+	if current == nil || sameKeySlots(current, data) {
+		if err := backupFile(path, s.previousPath(name), vaultFileMode); err != nil {
+			return fmt.Errorf("keeping the previous copy: %w", err)
+		}
+	} else if err := os.Remove(s.previousPath(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing the previous copy: %w", err)
+	}
+	return writeFileAtomic(path, data, vaultFileMode)
+}
+
+// Delete removes the vault file, the previous copy, the copies made before
+// migrations and the lock file. It waits for saves like another save, so one
+// can't write the vault back halfway through.
+func (s FileStore) Delete(name string) error {
+	unlock, err := lockFile(s.lockPath(name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(s.vaultPath(name)); err != nil {
+		return err
+	}
+	err = s.DropCopies(name)
+	if lockErr := os.Remove(s.lockPath(name)); lockErr != nil && !errors.Is(lockErr, fs.ErrNotExist) {
+		err = errors.Join(err, lockErr)
+	}
+	return err
+}
+
+// DropCopies removes the previous copy and every migration backup.
+func (s FileStore) DropCopies(name string) error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, file := range files {
+		backup, ok := strings.CutSuffix(file.Name(), ".bak")
+		if !ok || (backup != name && !isBackupOf(backup, name)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, file.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s FileStore) Backup(name string, version int) error {
+	vaultByte, err := s.Load(name)
+	if err != nil {
+		return err
+	}
+	backup, err := os.OpenFile(s.backupPath(name, version), os.O_WRONLY|os.O_CREATE|os.O_EXCL, vaultFileMode)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := backup.Write(vaultByte); err != nil {
+		backup.Close()
+		return err
+	}
+	if err := backup.Sync(); err != nil {
+		backup.Close()
+		return err
+	}
+	return backup.Close()
+}
+
+// CheckPermissions checks the directory and every file in it.
+func (s FileStore) CheckPermissions() ([]PermissionProblem, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{s.dir}
+	for _, file := range files {
+		paths = append(paths, filepath.Join(s.dir, file.Name()))
+	}
+	return checkPermissions(paths...)
+}
+
+func (s FileStore) FixPermissions() error {
+	problems, err := s.CheckPermissions()
+	if err != nil {
+		return err
+	}
+	return fixPermissions(problems)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	lg "github.com/charmbracelet/lipgloss"
+)
+
+type GeneratorModel struct {
+	keys      keyMap
+	help      help.Model
+	w, h      int
+	mainModel *mainModel
+	vault     Vault
+
+	options   GeneratorOptions
+	cursor    int
+	generated string
+	bits      float64
+	errorMsg  string
+}
+
+// Options shown in the generator, depending on its mode.
+const (
+	generatorMode = iota
+	generatorLength
+	generatorLower
+	generatorUpper
+	generatorDigits
+	generatorSymbols
+	generatorAmbiguous
+	generatorRequireEach
+	generatorWords
+	generatorSeparator
+	generatorCapitalization
+)
+
+func InitialGeneratorModel(mainmdl *mainModel) GeneratorModel {
+	m := GeneratorModel{
+		keys:      keysGenerator,
+		help:      help.New(),
+		mainModel: mainmdl,
+		options:   DefaultGeneratorOptions(),
+	}
+	return m
+}
+
+func (m GeneratorModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m GeneratorModel) View() string {
+	s := ""
+	s += titleStyle.Render(fmt.Sprintf("Generate a %s", highlightStyle.Render("secret.")))
+	s += "\n"
+
+	for i, option := range m.rows() {
+		row := fmt.Sprintf("%-18s %s", m.optionName(option), m.optionValue(option))
+		if m.cursor == i {
+			s += choicesFocusedStyle.Render("> " + row)
+		} else {
+			s += choicesStyle.Render("  " + row)
+		}
+		s += "\n"
+	}
+	s += "\n"
+
+	if m.generated != "" {
+		s += formBorderStyle.Render(highlightStyle.Render(m.generated))
+		s += "\n"
+		s += fmt.Sprintf("About %.0f bits of entropy, %s\n", m.bits, scoreNames[scoreForBits(m.bits)])
+		s += fmt.Sprintf("Press %s to use it.\n", highlightStyle.Render("enter"))
+	}
+	s += errorStyle.Render(fmt.Sprintf("%s\n", m.errorMsg))
+
+	helpView := m.help.View(m.keys)
+	s += helpStyle.Render(helpView)
+	s = lg.Place(m.w, m.h, lg.Center, lg.Center, s)
+	return s
+}
+
+func (m GeneratorModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if model, cmd, ok := m.mainModel.autoLock(m, msg, m.vault, false); ok {
+		return model, cmd
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		case key.Matches(msg, m.keys.Up):
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case key.Matches(msg, m.keys.Down):
+			if m.cursor < len(m.rows())-1 {
+				m.cursor++
+			}
+		case key.Matches(msg, m.keys.Toggle) || key.Matches(msg, m.keys.Increase):
+			m.changeOption(m.rows()[m.cursor], 1)
+			return m.generate(), nil
+		case key.Matches(msg, m.keys.Decrease):
+			m.changeOption(m.rows()[m.cursor], -1)
+			return m.generate(), nil
+		case key.Matches(msg, m.keys.Regenerate):
+			return m.generate(), nil
+		case key.Matches(msg, m.keys.Back):
+			return m.leave("", 0)
+		case key.Matches(msg, m.keys.Enter):
+			if m.generated == "" {
+				return m, nil
+			}
+			return m.leave(m.generated, m.bits)
+		}
+	case SendVaultMsg:
+		m.vault = msg.VaultSended
+		return m.generate(), nil
+	case tea.WindowSizeMsg:
+		m.w = msg.Width
+		m.h = msg.Height
+		m.help.Width = msg.Width
+	}
+	return m, nil
+}
+
+// leave goes back to the create secret form, handing it the generated text
+// unless it's empty. The options are kept for the next time.
+func (m GeneratorModel) leave(generated string, bits float64) (tea.Model, tea.Cmd) {
+	m.generated = ""
+	m.mainModel.generatorView = m
+	m.mainModel.viewState = createSecretView
+	return m.mainModel.createSecretView, tea.Batch(tea.WindowSize(), textinput.Blink, SendGeneratedCmd(generated, bits))
+}
+
+func (m GeneratorModel) generate() GeneratorModel {
+	var err error
+	m.generated, m.bits, err = Generate(m.options)
+	m.errorMsg = ""
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Can't generate: %v", err)
+	}
+	return m
+}
+
+func (m GeneratorModel) rows() []int {
+	if m.options.Mode == generatePassphrase {
+		return []int{generatorMode, generatorWords, generatorSeparator, generatorCapitalization}
+	}
+	return []int{generatorMode, generatorLength, generatorLower, generatorUpper, generatorDigits, generatorSymbols, generatorAmbiguous, generatorRequireEach}
+}
+
+func (m GeneratorModel) optionName(option int) string {
+	return map[int]string{
+		generatorMode:           "Mode",
+		generatorLength:         "Length",
+		generatorLower:          "Lowercase a-z",
+		generatorUpper:          "Uppercase A-Z",
+		generatorDigits:         "Digits 0-9",
+		generatorSymbols:        "Symbols",
+		generatorAmbiguous:      "Exclude 0Oo1lI|",
+		generatorRequireEach:    "Require each class",
+		generatorWords:          "Words",
+		generatorSeparator:      "Separator",
+		generatorCapitalization: "Capitalization",
+	}[option]
+}
+
+func (m GeneratorModel) optionValue(option int) string {
+	checkbox := func(checked bool) string {
+		if checked {
+			return "[x]"
+		}
+		return "[ ]"
+	}
+	o := m.options
+	switch option {
+	case generatorMode:
+		return generatorModeNames[o.Mode]
+	case generatorLength:
+		return fmt.Sprint(o.Length)
+	case generatorLower:
+		return checkbox(o.Lower)
+	case generatorUpper:
+		return checkbox(o.Upper)
+	case generatorDigits:
+		return checkbox(o.Digits)
+	case generatorSymbols:
+		return checkbox(o.Symbols)
+	case generatorAmbiguous:
+		return checkbox(o.ExcludeAmbiguous)
+	case generatorRequireEach:
+		return checkbox(o.RequireEach)
+	case generatorWords:
+		return fmt.Sprint(o.Words)
+	case generatorSeparator:
+		switch o.Separator {
+		case " ":
+			return "space"
+		case "":
+			return "none"
+		}
+		return o.Separator
+	case generatorCapitalization:
+		return capitalizationNames[o.Capitalization]
+	}
+	return ""
+}
+
+// changeOption steps a number or a choice by step, or flips a checkbox.
+func (m *GeneratorModel) changeOption(option, step int) {
+	o := &m.options
+	cycle := func(value, count int) int {
+		return (value + step + count) % count
+	}
+	switch option {
+	case generatorMode:
+		o.Mode = cycle(o.Mode, len(generatorModeNames))
+		m.cursor = 0
+	case generatorLength:
+		o.Length = min(max(o.Length+step, minGeneratedLength), maxGeneratedLength)
+	case generatorLower:
+		o.Lower = !o.Lower
+	case generatorUpper:
+		o.Upper = !o.Upper
+	case generatorDigits:
+		o.Digits = !o.Digits
+	case generatorSymbols:
+		o.Symbols = !o.Symbols
+	case generatorAmbiguous:
+		o.ExcludeAmbiguous = !o.ExcludeAmbiguous
+	case generatorRequireEach:
+		o.RequireEach = !o.RequireEach
+	case generatorWords:
+		o.Words = min(max(o.Words+step, minPassphraseWords), maxPassphraseWords)
+	case generatorSeparator:
+		current := 0
+		for i, separator := range passphraseSeparators {
+			if separator == o.Separator {
+				current = i
+			}
+		}
+		o.Separator = passphraseSeparators[cycle(current, len(passphraseSeparators))]
+	case generatorCapitalization:
+		o.Capitalization = cycle(o.Capitalization, len(capitalizationNames))
+	}
+}
+
+// Handing a generated secret text back to the create secret view.
+type SendGeneratedMsg struct {
+	Text string
+	Bits float64
+}
+
+func SendGeneratedCmd(text string, bits float64) tea.Cmd {
+	if text == "" {
+		return nil
+	}
+	return func() tea.Msg {
+		return SendGeneratedMsg{Text: text, Bits: bits}
+	}
+}
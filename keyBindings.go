@@ -23,7 +23,79 @@ func VaultKeyMap() keyMap {
 	keys.Full = [][]key.Binding{
 		{keys.Up, keys.Down, keys.Back},
 		{keys.Quit, keys.Enter, keys.Help},
-		{keys.Create, keys.Delete},
+		{keys.Create, keys.Edit, keys.Delete},
+		{keys.Reveal, keys.CopyText, keys.CopyName},
+		{keys.ChangePassword, keys.RotateKey, keys.KeySlots},
+		{keys.Lock},
+	}
+	return keys
+}
+
+// Key bindings for the change password view.
+var keysChangePassword = ChangePasswordKeyMap()
+
+func ChangePasswordKeyMap() keyMap {
+	keys := newKeyMap()
+	keys.Full = [][]key.Binding{
+		{keys.Up, keys.Down, keys.Back},
+		{keys.Quit, keys.Enter, keys.Help},
+		{keys.Lock},
+	}
+	return keys
+}
+
+// Key bindings for the rotate key view.
+var keysRotateKey = RotateKeyKeyMap()
+
+func RotateKeyKeyMap() keyMap {
+	keys := newKeyMap()
+	keys.Full = [][]key.Binding{
+		{keys.Up, keys.Down, keys.Back},
+		{keys.Quit, keys.Enter, keys.Help},
+		{keys.Lock},
+	}
+	return keys
+}
+
+// RotatedKeyMap is used once a rotation removed key slots, with the key for
+// new recovery codes if some of them were recovery codes.
+func RotatedKeyMap(recoveryDropped bool) keyMap {
+	keys := newKeyMap()
+	keys.Enter.SetHelp("enter", "back to vault")
+	keys.Full = [][]key.Binding{
+		{keys.Enter, keys.Back},
+		{keys.Quit, keys.Help},
+		{keys.Lock},
+	}
+	if recoveryDropped {
+		keys.Full[0] = append(keys.Full[0], keys.RecoveryCodes)
+	}
+	return keys
+}
+
+// Key bindings for the key slots view.
+var keysKeySlots = KeySlotsKeyMap()
+
+func KeySlotsKeyMap() keyMap {
+	keys := newKeyMap()
+	keys.Delete.SetHelp("d", "remove slot")
+	keys.Full = [][]key.Binding{
+		{keys.Up, keys.Down, keys.Back},
+		{keys.Quit, keys.Enter, keys.Help},
+		{keys.AddPassword, keys.AddKeyfile, keys.RecoveryCodes, keys.Delete},
+		{keys.Lock},
+	}
+	return keys
+}
+
+// Key bindings for the recovery codes view.
+var keysRecoveryCodes = RecoveryCodesKeyMap()
+
+func RecoveryCodesKeyMap() keyMap {
+	keys := newKeyMap()
+	keys.Full = [][]key.Binding{
+		{keys.Confirm},
+		{keys.Quit, keys.Help},
 	}
 	return keys
 }
@@ -36,6 +108,35 @@ func CreateSecretKeyMap() keyMap {
 	keys.Full = [][]key.Binding{
 		{keys.Up, keys.Down, keys.Back},
 		{keys.Quit, keys.Enter, keys.Help},
+		{keys.Generate, keys.ToggleSensitive, keys.Lock},
+	}
+	return keys
+}
+
+// Key bindings for the generator view.
+var keysGenerator = GeneratorKeyMap()
+
+func GeneratorKeyMap() keyMap {
+	keys := newKeyMap()
+	keys.Enter.SetHelp("enter", "use it")
+	keys.Full = [][]key.Binding{
+		{keys.Up, keys.Down, keys.Back},
+		{keys.Quit, keys.Enter, keys.Help},
+		{keys.Toggle, keys.Increase, keys.Decrease, keys.Regenerate},
+		{keys.Lock},
+	}
+	return keys
+}
+
+// Key bindings for the conflict view.
+var keysConflict = ConflictKeyMap()
+
+func ConflictKeyMap() keyMap {
+	keys := newKeyMap()
+	keys.Full = [][]key.Binding{
+		{keys.Up, keys.Down},
+		{keys.Quit, keys.Enter, keys.Help},
+		{keys.Lock},
 	}
 	return keys
 }
@@ -48,6 +149,7 @@ func CreateVaultKeyMap() keyMap {
 	keys.Full = [][]key.Binding{
 		{keys.Up, keys.Down, keys.Back},
 		{keys.Quit, keys.Enter, keys.Help},
+		{keys.ToggleSeal, keys.ToggleCipher, keys.ToggleMinStrength},
 	}
 	return keys
 }
@@ -72,7 +174,7 @@ func VaultsKeyMap() keyMap {
 	keys.Full = [][]key.Binding{
 		{keys.Up, keys.Down, keys.Back},
 		{keys.Quit, keys.Enter, keys.Help},
-		{keys.Delete},
+		{keys.Delete, keys.FixPermissions},
 	}
 	return keys
 }
@@ -86,8 +188,37 @@ type keyMap struct {
 	Enter  key.Binding
 	Back   key.Binding
 	Create key.Binding
+	Edit   key.Binding
 	Delete key.Binding
-	Full   [][]key.Binding
+
+	ChangePassword key.Binding
+	RotateKey      key.Binding
+	KeySlots       key.Binding
+	AddPassword    key.Binding
+	AddKeyfile     key.Binding
+	RecoveryCodes  key.Binding
+	Confirm        key.Binding
+	ToggleSeal     key.Binding
+	ToggleCipher   key.Binding
+	Lock           key.Binding
+
+	ToggleMinStrength key.Binding
+
+	Generate   key.Binding
+	Toggle     key.Binding
+	Increase   key.Binding
+	Decrease   key.Binding
+	Regenerate key.Binding
+
+	CopyText key.Binding
+	CopyName key.Binding
+
+	Reveal          key.Binding
+	ToggleSensitive key.Binding
+
+	FixPermissions key.Binding
+
+	Full [][]key.Binding
 }
 
 func (k keyMap) ShortHelp() []key.Binding {
@@ -132,5 +263,93 @@ func newKeyMap() keyMap {
 			key.WithKeys("c"),
 			key.WithHelp("c", "create secret"),
 		),
+		Edit: key.NewBinding(
+			key.WithKeys("e"),
+			key.WithHelp("e", "edit secret"),
+		),
+		ChangePassword: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "change password"),
+		),
+		RotateKey: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "rotate vault key"),
+		),
+		KeySlots: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s", "key slots"),
+		),
+		AddPassword: key.NewBinding(
+			key.WithKeys("a"),
+			key.WithHelp("a", "add password slot"),
+		),
+		AddKeyfile: key.NewBinding(
+			key.WithKeys("k"),
+			key.WithHelp("k", "add keyfile slot"),
+		),
+		RecoveryCodes: key.NewBinding(
+			key.WithKeys("g"),
+			key.WithHelp("g", "new recovery codes"),
+		),
+		Confirm: key.NewBinding(
+			key.WithKeys("y"),
+			key.WithHelp("y", "i saved them"),
+		),
+		ToggleSeal: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "change vault format"),
+		),
+		ToggleCipher: key.NewBinding(
+			key.WithKeys("ctrl+t"),
+			key.WithHelp("ctrl+t", "change cipher"),
+		),
+		Lock: key.NewBinding(
+			key.WithKeys("ctrl+l"),
+			key.WithHelp("ctrl+l", "lock vault"),
+		),
+		ToggleMinStrength: key.NewBinding(
+			key.WithKeys("ctrl+r"),
+			key.WithHelp("ctrl+r", "change required strength"),
+		),
+		Generate: key.NewBinding(
+			key.WithKeys("ctrl+g"),
+			key.WithHelp("ctrl+g", "generate secret text"),
+		),
+		Toggle: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "change option"),
+		),
+		Increase: key.NewBinding(
+			key.WithKeys("+", "="),
+			key.WithHelp("+", "increase"),
+		),
+		Decrease: key.NewBinding(
+			key.WithKeys("-"),
+			key.WithHelp("-", "decrease"),
+		),
+		Regenerate: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "generate again"),
+		),
+		CopyText: key.NewBinding(
+			key.WithKeys("y"),
+			key.WithHelp("y", "copy secret text"),
+		),
+		CopyName: key.NewBinding(
+			key.WithKeys("n"),
+			key.WithHelp("n", "copy secret name"),
+		),
+		Reveal: key.NewBinding(
+			key.WithKeys("v"),
+			key.WithHelp("v", "reveal secret"),
+		),
+		ToggleSensitive: key.NewBinding(
+			key.WithKeys("ctrl+s"),
+			key.WithHelp("ctrl+s", "mark sensitive"),
+		),
+		FixPermissions: key.NewBinding(
+			key.WithKeys("f"),
+			key.WithHelp("f", "fix permissions"),
+		),
 	}
 }
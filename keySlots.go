@@ -0,0 +1,510 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Each key slot holds its own wrapped copy of the vault key, so a vault can
+// be opened with any of them.
+const (
+	slotPassword = "password"
+	slotKeyfile  = "keyfile"
+	slotRecovery = "recovery"
+)
+
+type KeySlot struct {
+	ID                       string    `json:"ID"`
+	Type                     string    `json:"Type"`
+	Label                    string    `json:"Label,omitempty"`
+	Keyfile                  bool      `json:"Keyfile,omitempty"` // password slot that also needs a keyfile
+	KDF                      KDFParams `json:"KDF"`
+	Cipher                   string    `json:"Cipher,omitempty"`
+	EncodedEncryptedVaultKey string    `json:"EncodedEncryptedVaultKey"`
+	EncodedSalt              string    `json:"EncodedSalt"`
+	EncodedNonce             string    `json:"EncodedNonce"`
+	CreatedAt                time.Time `json:"CreatedAt"`
+}
+
+// Credentials are whatever the user supplied to unlock a vault. Empty fields
+// are skipped.
+type Credentials struct {
+	Password string
+	Keyfile  []byte
+}
+
+var ErrLastKeySlot = errors.New("can't remove the last key slot of a vault")
+
+// ErrRecoveryKeyfile is returned when a new master password is set after a
+// recovery without the keyfile the old one needed.
+var ErrRecoveryKeyfile = errors.New("the master password of this vault needs a keyfile, the new one too")
+
+func newRandomID(size int) (string, error) {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func newSlotID() (string, error) {
+	return newRandomID(4)
+}
+
+// kdfParamsFor returns the key derivation parameters new slots of the given
+// type are created with.
+func kdfParamsFor(slotType string) KDFParams {
+	if slotType == slotRecovery {
+		return recoveryKDFParams()
+	}
+	return DefaultKDFParams()
+}
+
+// NewKeySlot wraps vaultKey under secret with the key derivation parameters
+// for its type, using the given cipher.
+func NewKeySlot(slotType, label string, secret, vaultKey []byte, cipherName string) (KeySlot, error) {
+	id, err := newSlotID()
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	slot := KeySlot{
+		ID:        id,
+		Type:      slotType,
+		Label:     label,
+		KDF:       kdfParamsFor(slotType),
+		Cipher:    cipherName,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	slot.EncodedEncryptedVaultKey, slot.EncodedSalt, slot.EncodedNonce, err = WrapVaultKey(vaultKey, secret, slot.KDF, slot.Cipher)
+	if err != nil {
+		return KeySlot{}, err
+	}
+	return slot, nil
+}
+
+// Unwrap returns the vault key if secret opens this slot.
+func (s KeySlot) Unwrap(secret []byte) ([]byte, bool) {
+	return UnwrapVaultKey(secret, s.EncodedSalt, s.EncodedEncryptedVaultKey, s.EncodedNonce, s.KDF, s.Cipher)
+}
+
+// unlock returns the vault key if creds open this slot. Passwords are tried
+// normalized first and then as typed, for slots wrapped before passwords were
+// normalized.
+func (s KeySlot) unlock(creds Credentials) ([]byte, bool) {
+	secret := creds.secretFor(s)
+	if secret == nil {
+		return nil, false
+	}
+	if vaultKey, ok := s.Unwrap(secret); ok {
+		return vaultKey, true
+	}
+	if raw := creds.rawSecretFor(s); raw != nil {
+		return s.Unwrap(raw)
+	}
+	return nil, false
+}
+
+// opens reports whether creds open this slot, without keeping the key.
+func (s KeySlot) opens(creds Credentials) bool {
+	vaultKey, ok := s.unlock(creds)
+	wipeBytes(vaultKey)
+	return ok
+}
+
+// rewrap wraps vaultKey under secret again with the given cipher, keeping the
+// slot's identity.
+func (s KeySlot) rewrap(secret, vaultKey []byte, cipherName string) (KeySlot, error) {
+	var err error
+	s.KDF = kdfParamsFor(s.Type)
+	s.Cipher = cipherName
+	s.EncodedEncryptedVaultKey, s.EncodedSalt, s.EncodedNonce, err = WrapVaultKey(vaultKey, secret, s.KDF, s.Cipher)
+	return s, err
+}
+
+// passwordWithKeyfile mixes a keyfile into a password for slots that need
+// both. The keyfile is hashed so its size doesn't matter.
+func passwordWithKeyfile(password string, keyfile []byte) []byte {
+	keyfileHash := sha256.Sum256(keyfile)
+	secret := append([]byte(password), 0)
+	return append(secret, keyfileHash[:]...)
+}
+
+// secretFor returns what creds offer for slot, or nil if they offer nothing
+// that could open it.
+func (creds Credentials) secretFor(slot KeySlot) []byte {
+	switch slot.Type {
+	case slotPassword:
+		return creds.passwordSecret(slot, normalizePassword(creds.Password))
+	case slotKeyfile:
+		if creds.Keyfile != nil {
+			return creds.Keyfile
+		}
+	case slotRecovery:
+		if code, ok := normalizeRecoveryCode(creds.Password); ok {
+			return []byte(code)
+		}
+	}
+	return nil
+}
+
+// rawSecretFor is secretFor with the password as it was typed, for password
+// slots wrapped before passwords were normalized. It's nil if that's no
+// different from secretFor.
+func (creds Credentials) rawSecretFor(slot KeySlot) []byte {
+	if slot.Type != slotPassword || creds.Password == normalizePassword(creds.Password) {
+		return nil
+	}
+	return creds.passwordSecret(slot, creds.Password)
+}
+
+func (creds Credentials) passwordSecret(slot KeySlot, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	if slot.Keyfile {
+		if creds.Keyfile != nil {
+			return passwordWithKeyfile(password, creds.Keyfile)
+		}
+		return nil
+	}
+	return []byte(password)
+}
+
+// normalizePassword brings a password into Unicode NFKC form before a key is
+// derived from it, so the same passphrase typed on another keyboard or
+// terminal opens the vault too.
+func normalizePassword(password string) string {
+	return norm.NFKC.String(password)
+}
+
+// UnlockVault tries every key slot the credentials fit and returns the vault
+// key, in locked memory, together with the index of the slot that opened it.
+func UnlockVault(vault Vault, creds Credentials) (*LockedBuffer, int, error) {
+	for i, slot := range vault.KeySlots {
+		if vaultKey, ok := slot.unlock(creds); ok {
+			return LockBytes(vaultKey), i, nil
+		}
+	}
+	return nil, -1, ErrWrongPassword
+}
+
+// ConfirmPassword checks the credentials against the password slots only.
+// It's for making sure whoever sits in front of an unlocked vault knows its
+// master password, which a recovery code or a keyfile on its own doesn't
+// prove.
+func ConfirmPassword(vault Vault, creds Credentials) error {
+	for _, slot := range vault.KeySlots {
+		if slot.Type != slotPassword {
+			continue
+		}
+		if vaultKey, ok := slot.unlock(creds); ok {
+			wipeBytes(vaultKey)
+			return nil
+		}
+	}
+	return ErrWrongPassword
+}
+
+// describe names the slot in messages, like "recovery code 2 (recovery,
+// 3f2a9c1e)".
+func (s KeySlot) describe() string {
+	label := s.Label
+	if label == "" {
+		label = s.Type + " slot"
+	}
+	return fmt.Sprintf("%s (%s, %s)", label, s.Type, s.ID)
+}
+
+// hasRecoverySlot reports whether any of slots is a recovery code.
+func hasRecoverySlot(slots []KeySlot) bool {
+	return slices.ContainsFunc(slots, func(slot KeySlot) bool {
+		return slot.Type == slotRecovery
+	})
+}
+
+// AddKeySlot appends slot to the vault and saves it.
+func AddKeySlot(vault Vault, slot KeySlot) (Vault, error) {
+	changed := vault
+	changed.KeySlots = append(append([]KeySlot{}, vault.KeySlots...), slot)
+	saved, err := SaveVault(changed)
+	if err != nil {
+		return vault, err
+	}
+	return saved, nil
+}
+
+// RemoveKeySlot drops the slot with the given id and saves the vault. The
+// last slot can't be removed since the vault could never be opened again. The
+// copies the store kept of the vault go too.
+func RemoveKeySlot(vault Vault, id string) (Vault, error) {
+	index := -1
+	for i, slot := range vault.KeySlots {
+		if slot.ID == id {
+			index = i
+		}
+	}
+	if index == -1 {
+		return vault, fmt.Errorf("no key slot with id %q", id)
+	}
+	if len(vault.KeySlots) == 1 {
+		return vault, ErrLastKeySlot
+	}
+
+	changed := vault
+	changed.KeySlots = append(append([]KeySlot{}, vault.KeySlots[:index]...), vault.KeySlots[index+1:]...)
+	if err := dropRevokedCopies(vault); err != nil {
+		return vault, err
+	}
+	saved, err := SaveVault(changed)
+	if err != nil {
+		return vault, err
+	}
+	return saved, nil
+}
+
+// dropRevokedCopies removes the copies the store kept of a vault before one
+// of its slots is revoked, since they would still open with it. A later copy
+// can't bring the slot back: the store drops the previous copy whenever the
+// key slots change.
+func dropRevokedCopies(vault Vault) error {
+	if err := store.DropCopies(vault.FileName()); err != nil {
+		return fmt.Errorf("removing the copies kept of the vault: %w", err)
+	}
+	return nil
+}
+
+// Recovery codes are 15 random bytes written as six groups of base32.
+const (
+	recoveryCodeBytes = 15
+	recoveryCodeCount = 8
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateRecoveryCode() (string, error) {
+	code := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(code); err != nil {
+		return "", err
+	}
+	encoded := recoveryEncoding.EncodeToString(code)
+
+	groups := []string{}
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:i+4])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// GenerateRecoveryCodes replaces the recovery code slots of the vault with a
+// fresh set and saves it. The codes are returned so they can be shown once;
+// only their wrapped vault keys are stored.
+func GenerateRecoveryCodes(vault Vault, vaultKey *LockedBuffer) (Vault, []string, error) {
+	changed, err := OpenVault(vault, vaultKey)
+	if err != nil {
+		return vault, nil, err
+	}
+	changed.KeySlots = []KeySlot{}
+	for _, slot := range vault.KeySlots {
+		if slot.Type != slotRecovery {
+			changed.KeySlots = append(changed.KeySlots, slot)
+		}
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := GenerateRecoveryCode()
+		if err != nil {
+			return vault, nil, err
+		}
+		normalized, _ := normalizeRecoveryCode(code)
+		slot, err := NewKeySlot(slotRecovery, fmt.Sprintf("recovery code %d", i+1), []byte(normalized), vaultKey.Bytes(), vault.CipherName())
+		if err != nil {
+			return vault, nil, err
+		}
+		codes[i] = code
+		changed.KeySlots = append(changed.KeySlots, slot)
+	}
+
+	// The old codes would still open the copies
+	if err := dropRevokedCopies(vault); err != nil {
+		return vault, nil, err
+	}
+	saved, err := SaveVault(changed)
+	if err != nil {
+		return vault, nil, err
+	}
+	return saved, codes, nil
+}
+
+// RecoverVault sets a new master password after the vault was opened with
+// the recovery code in slot recoverySlot, and burns that code. The new
+// password replaces the first password slot. If that one needed a keyfile the
+// new one does too, so creds has to carry one or ErrRecoveryKeyfile is
+// returned. The copies the store kept of the vault are removed.
+func RecoverVault(vault Vault, recoverySlot int, vaultKey *LockedBuffer, creds Credentials) (Vault, error) {
+	if vault.KeySlots[recoverySlot].Type != slotRecovery {
+		return vault, fmt.Errorf("slot %s isn't a recovery code", vault.KeySlots[recoverySlot].ID)
+	}
+
+	masterSlot := masterSlotIndex(vault)
+	passwordSlot := KeySlot{Type: slotPassword, Keyfile: MasterNeedsKeyfile(vault)}
+	if passwordSlot.Keyfile && creds.Keyfile == nil {
+		return vault, ErrRecoveryKeyfile
+	}
+	slot, err := NewKeySlot(slotPassword, "master password", creds.secretFor(passwordSlot), vaultKey.Bytes(), vault.CipherName())
+	if err != nil {
+		return vault, err
+	}
+	slot.Keyfile = passwordSlot.Keyfile
+
+	changed, err := OpenVault(vault, vaultKey)
+	if err != nil {
+		return vault, err
+	}
+	changed.KeySlots = []KeySlot{}
+	for i, s := range vault.KeySlots {
+		switch i {
+		case recoverySlot:
+			// Burn the used code
+		case masterSlot:
+			changed.KeySlots = append(changed.KeySlots, slot)
+		default:
+			changed.KeySlots = append(changed.KeySlots, s)
+		}
+	}
+	if masterSlot == -1 {
+		changed.KeySlots = append(changed.KeySlots, slot)
+	}
+
+	// The burned code and the old password would still open the copies
+	if err := dropRevokedCopies(vault); err != nil {
+		return vault, err
+	}
+	saved, err := SaveVault(changed)
+	if err != nil {
+		return vault, err
+	}
+	return saved, nil
+}
+
+// masterSlotIndex returns the index of the first password slot, the one a
+// recovery sets a new password in, or -1 if there's none.
+func masterSlotIndex(vault Vault) int {
+	return slices.IndexFunc(vault.KeySlots, func(slot KeySlot) bool {
+		return slot.Type == slotPassword
+	})
+}
+
+// MasterNeedsKeyfile reports whether the master password, the one a recovery
+// replaces, needs a keyfile next to it.
+func MasterNeedsKeyfile(vault Vault) bool {
+	masterSlot := masterSlotIndex(vault)
+	return masterSlot != -1 && vault.KeySlots[masterSlot].Keyfile
+}
+
+// normalizeRecoveryCode strips dashes and spaces and upper cases the code so
+// it can be typed loosely. It reports false if input can't be a recovery code.
+func normalizeRecoveryCode(input string) (string, bool) {
+	code := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(input))
+	decoded, err := recoveryEncoding.DecodeString(code)
+	if err != nil || len(decoded) != recoveryCodeBytes {
+		return "", false
+	}
+	return code, true
+}
+
+// RequiresKeyfile reports whether any password slot of the vault needs a
+// keyfile next to the password.
+func (v Vault) RequiresKeyfile() bool {
+	for _, slot := range v.KeySlots {
+		if slot.Type == slotPassword && slot.Keyfile {
+			return true
+		}
+	}
+	return false
+}
+
+const keyfileSize = 64
+
+// GenerateKeyfile writes a new random keyfile to path. An existing file is
+// never overwritten.
+func GenerateKeyfile(path string) error {
+	keyfile := make([]byte, keyfileSize)
+	if _, err := rand.Read(keyfile); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(keyfile); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// NewCredentials reads the keyfile at keyfilePath, if one is given, and
+// bundles it with password.
+func NewCredentials(password, keyfilePath string) (Credentials, error) {
+	creds := Credentials{Password: password}
+	if keyfilePath == "" {
+		return creds, nil
+	}
+	keyfile, err := ReadKeyfile(keyfilePath)
+	if err != nil {
+		return creds, err
+	}
+	creds.Keyfile = keyfile
+	return creds, nil
+}
+
+// ReadKeyfile reads a keyfile used to unlock a vault.
+func ReadKeyfile(path string) ([]byte, error) {
+	keyfile, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyfile) == 0 {
+		return nil, fmt.Errorf("keyfile %s is empty", path)
+	}
+	return keyfile, nil
+}
+
+// normalizeKeySlots moves the single wrapped key that vaults had before key
+// slots existed into a password slot.
+func normalizeKeySlots(vault *Vault) {
+	if len(vault.KeySlots) > 0 || vault.EncodedEncryptedVaultKey == "" {
+		return
+	}
+
+	kdf := legacyKDFParams()
+	if vault.KDF != nil && vault.KDF.Algorithm != "" {
+		kdf = *vault.KDF
+	}
+	vault.KeySlots = []KeySlot{{
+		ID:                       "legacy",
+		Type:                     slotPassword,
+		Label:                    "master password",
+		KDF:                      kdf,
+		EncodedEncryptedVaultKey: vault.EncodedEncryptedVaultKey,
+		EncodedSalt:              vault.EncodedSalt,
+		EncodedNonce:             vault.EncodedNonce,
+	}}
+	vault.EncodedEncryptedVaultKey = ""
+	vault.EncodedSalt = ""
+	vault.EncodedNonce = ""
+	vault.KDF = nil
+}
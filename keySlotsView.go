@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	lg "github.com/charmbracelet/lipgloss"
+)
+
+type KeySlotsModel struct {
+	keys      keyMap
+	help      help.Model
+	w, h      int
+	mainModel *mainModel
+
+	errorMsg        string
+	confirmationMsg string
+	cursor          int
+
+	// adding is the type of the slot being added, empty while browsing.
+	adding     string
+	focusIndex int
+	inputs     []textinput.Model
+
+	decryptedVaultKey *LockedBuffer
+	vault             Vault
+}
+
+const (
+	slotLabel = iota
+	slotSecret
+	slotReSecret
+)
+
+func InitialKeySlotsModel(mainmdl *mainModel) KeySlotsModel {
+	m := KeySlotsModel{
+		keys:      keysKeySlots,
+		help:      help.New(),
+		mainModel: mainmdl,
+	}
+	return m
+}
+
+func (m KeySlotsModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m KeySlotsModel) View() string {
+	s := ""
+	s += titleStyle.Render(fmt.Sprintf("Key slots of %s", highlightStyle.Render(m.vault.Name)))
+	s += "\n"
+
+	if m.adding != "" {
+		var b strings.Builder
+		for i := range m.inputs {
+			b.WriteString(m.inputs[i].View())
+			if i < len(m.inputs)-1 {
+				b.WriteRune('\n')
+			}
+		}
+		s += formBorderStyle.Render(b.String())
+		s += "\n"
+		s += fmt.Sprintf("Press %s to add the %s slot.\n", highlightStyle.Render("enter"), m.adding)
+	} else {
+		v := ""
+		for i, slot := range m.vault.KeySlots {
+			style := listItemStyle
+			if m.cursor == i {
+				style = listItemHighlightStyle
+			}
+			v += style.Render(fmt.Sprintf("%s\n%s", slot.Type, listItemDescriptionStyle.Render(slotDescription(slot))))
+			v += "\n"
+		}
+		s += listStyle.Render(v)
+		s += "\n"
+	}
+
+	s += errorStyle.Render(fmt.Sprintf("%s\n", m.errorMsg))
+	s += confirmationStyle.Render(fmt.Sprintf("%s\n", m.confirmationMsg))
+
+	helpView := m.help.View(m.keys)
+	s += helpStyle.Render(helpView)
+	s = lg.Place(m.w, m.h, lg.Center, lg.Center, s)
+	return s
+}
+
+func slotDescription(slot KeySlot) string {
+	description := slot.ID
+	if slot.Label != "" {
+		description = fmt.Sprintf("%s (%s)", slot.Label, slot.ID)
+	}
+	if !slot.CreatedAt.IsZero() {
+		description += "\n" + slot.CreatedAt.Local().Format("2006-01-02 15:04")
+	}
+	return description
+}
+
+func (m KeySlotsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if model, cmd, ok := m.mainModel.autoLock(m, msg, m.vault, false); ok {
+		return model, cmd
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if m.adding != "" {
+			return m.updateAdding(msg)
+		}
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		case key.Matches(msg, m.keys.Up):
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case key.Matches(msg, m.keys.Down):
+			if m.cursor < len(m.vault.KeySlots)-1 {
+				m.cursor++
+			}
+		case key.Matches(msg, m.keys.Back):
+			m.mainModel.keySlotsView = InitialKeySlotsModel(m.mainModel)
+			m.mainModel.viewState = vaultView
+			return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey))
+		case key.Matches(msg, m.keys.AddPassword):
+			return m.startAdding(slotPassword)
+		case key.Matches(msg, m.keys.AddKeyfile):
+			return m.startAdding(slotKeyfile)
+		case key.Matches(msg, m.keys.RecoveryCodes):
+			return m.handleRecoveryCodes()
+		case key.Matches(msg, m.keys.Delete):
+			return m.handleRemove()
+		}
+	case SendDecryptedVaultKeyMsg:
+		m.decryptedVaultKey = msg.VaultKey
+		return m, nil
+	case SendVaultMsg:
+		m.vault = msg.VaultSended
+		return m, nil
+	case tea.WindowSizeMsg:
+		m.w = msg.Width
+		m.h = msg.Height
+		m.help.Width = msg.Width
+	}
+
+	// Handle character input and blinking
+	cmd := m.updateInputs(msg)
+	return m, cmd
+}
+
+func (m KeySlotsModel) updateAdding(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Quit):
+		return m, tea.Quit
+	case key.Matches(msg, m.keys.Back):
+		m.adding = ""
+		m.inputs = nil
+		m.errorMsg = ""
+		return m, nil
+	case key.Matches(msg, m.keys.Enter):
+		return m.handleAdd()
+	case key.Matches(msg, m.keys.Up) || key.Matches(msg, m.keys.Down):
+		// Cycle indexes
+		if key.Matches(msg, m.keys.Up) {
+			m.focusIndex--
+		} else if key.Matches(msg, m.keys.Down) {
+			m.focusIndex++
+		}
+
+		if m.focusIndex > len(m.inputs)-1 {
+			m.focusIndex = 0
+		} else if m.focusIndex < 0 {
+			m.focusIndex = len(m.inputs) - 1
+		}
+
+		cmds := make([]tea.Cmd, len(m.inputs))
+		for i := 0; i < len(m.inputs); i++ {
+			if i == m.focusIndex {
+				// Set focused state
+				cmds[i] = m.inputs[i].Focus()
+				m.inputs[i].PromptStyle = focusedStyle
+				m.inputs[i].TextStyle = focusedStyle
+				continue
+			}
+			// Remove focused state
+			m.inputs[i].Blur()
+			m.inputs[i].PromptStyle = noStyle
+			m.inputs[i].TextStyle = noStyle
+		}
+
+		return m, tea.Batch(cmds...)
+	}
+
+	cmd := m.updateInputs(msg)
+	return m, cmd
+}
+
+func (m *KeySlotsModel) updateInputs(msg tea.Msg) tea.Cmd {
+	cmds := make([]tea.Cmd, len(m.inputs))
+
+	// Only text inputs with Focus() set will respond, so it's safe to simply
+	// update all of them here without any further logic.
+	for i := range m.inputs {
+		m.inputs[i], cmds[i] = m.inputs[i].Update(msg)
+	}
+
+	return tea.Batch(cmds...)
+}
+
+func (m KeySlotsModel) startAdding(slotType string) (tea.Model, tea.Cmd) {
+	m.adding = slotType
+	m.focusIndex = 0
+	m.errorMsg = ""
+	m.confirmationMsg = ""
+
+	count := 3
+	if slotType == slotKeyfile {
+		count = 2
+	}
+	m.inputs = make([]textinput.Model, count)
+
+	var t textinput.Model
+	for i := range m.inputs {
+		t = textinput.New()
+		t.Cursor.Style = cursorStyle
+
+		switch i {
+		case slotLabel:
+			t.Placeholder = "Label"
+			t.CharLimit = 32
+			t.Focus()
+			t.PromptStyle = focusedStyle
+			t.TextStyle = focusedStyle
+		case slotSecret:
+			if slotType == slotKeyfile {
+				t.Placeholder = "Keyfile path"
+				t.CharLimit = 256
+			} else {
+				t.Placeholder = "Password"
+				t.EchoMode = textinput.EchoPassword
+				t.EchoCharacter = '•'
+			}
+		case slotReSecret:
+			t.Placeholder = "Re-enter password"
+			t.EchoMode = textinput.EchoPassword
+			t.EchoCharacter = '•'
+		}
+		m.inputs[i] = t
+	}
+	return m, textinput.Blink
+}
+
+func (m KeySlotsModel) handleAdd() (tea.Model, tea.Cmd) {
+	var secret []byte
+	switch m.adding {
+	case slotPassword:
+		if ok, errMsg := MasterPasswordValidation(m.inputs[slotSecret].Value(), m.inputs[slotReSecret].Value()); !ok {
+			m.errorMsg = errMsg
+			return m, nil
+		}
+		if ok, errMsg := PasswordStrengthValidation(m.inputs[slotSecret].Value(), m.vault.MinPasswordScore); !ok {
+			m.errorMsg = errMsg
+			return m, nil
+		}
+		secret = []byte(normalizePassword(m.inputs[slotSecret].Value()))
+	case slotKeyfile:
+		keyfile, err := ReadKeyfile(strings.TrimSpace(m.inputs[slotSecret].Value()))
+		if err != nil {
+			m.errorMsg = fmt.Sprintf("Error reading keyfile: %v", err)
+			return m, nil
+		}
+		secret = keyfile
+	}
+
+	slot, err := NewKeySlot(m.adding, strings.TrimSpace(m.inputs[slotLabel].Value()), secret, m.decryptedVaultKey.Bytes(), m.vault.CipherName())
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error adding key slot: %v", err)
+		return m, nil
+	}
+	vault, err := AddKeySlot(m.vault, slot)
+	if model, cmd, ok := m.mainModel.vaultConflict(err, m.vault, m.decryptedVaultKey); ok {
+		return model, cmd
+	} else if err != nil {
+		m.errorMsg = fmt.Sprintf("Error adding key slot: %v", err)
+		return m, nil
+	}
+
+	m.vault = vault
+	m.cursor = len(m.vault.KeySlots) - 1
+	m.confirmationMsg = fmt.Sprintf("Added %s slot %s", m.adding, slot.ID)
+	m.errorMsg = ""
+	m.adding = ""
+	m.inputs = nil
+	return m, nil
+}
+
+func (m KeySlotsModel) handleRecoveryCodes() (tea.Model, tea.Cmd) {
+	vault, codes, err := GenerateRecoveryCodes(m.vault, m.decryptedVaultKey)
+	if model, cmd, ok := m.mainModel.vaultConflict(err, m.vault, m.decryptedVaultKey); ok {
+		return model, cmd
+	} else if err != nil {
+		m.errorMsg = fmt.Sprintf("Error generating recovery codes: %v", err)
+		return m, nil
+	}
+
+	m.mainModel.viewState = recoveryCodesView
+	return m.mainModel.recoveryCodesView, tea.Batch(tea.WindowSize(), SendVaultCmd(vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey), SendRecoveryCodesCmd(codes, keySlotsView))
+}
+
+func (m KeySlotsModel) handleRemove() (tea.Model, tea.Cmd) {
+	if len(m.vault.KeySlots) == 0 {
+		return m, nil
+	}
+	slot := m.vault.KeySlots[m.cursor]
+	vault, err := RemoveKeySlot(m.vault, slot.ID)
+	if model, cmd, ok := m.mainModel.vaultConflict(err, m.vault, m.decryptedVaultKey); ok {
+		return model, cmd
+	} else if errors.Is(err, ErrLastKeySlot) {
+		m.errorMsg = "Can't remove the last key slot!"
+		return m, nil
+	} else if err != nil {
+		m.errorMsg = fmt.Sprintf("Error removing key slot: %v", err)
+		return m, nil
+	}
+
+	m.vault = vault
+	// reset cursor
+	m.cursor = 0
+	m.errorMsg = ""
+	m.confirmationMsg = fmt.Sprintf("Removed %s slot %s", slot.Type, slot.ID)
+	return m, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRecoverVaultKeepsKeyfile(t *testing.T) {
+	useMemoryStore(t)
+	vault := newTestVault(t, "correct horse battery staple")
+	keyfile := []byte("a keyfile of the master password")
+	creds := Credentials{Password: "correct horse battery staple", Keyfile: keyfile}
+	passwordSlot := KeySlot{Type: slotPassword, Keyfile: true}
+	slot, err := NewKeySlot(slotPassword, "master password", creds.secretFor(passwordSlot), vault.vaultKey.Bytes(), vault.CipherName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot.Keyfile = true
+	vault.KeySlots = []KeySlot{slot}
+	vault, _, err = GenerateRecoveryCodes(vault, vault.vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoverySlot := len(vault.KeySlots) - 1
+
+	newPassword := "gravel wombat tundra lantern"
+	if _, err := RecoverVault(vault, recoverySlot, vault.vaultKey, Credentials{Password: newPassword}); !errors.Is(err, ErrRecoveryKeyfile) {
+		t.Fatalf("RecoverVault() without a keyfile = %v, want ErrRecoveryKeyfile", err)
+	}
+
+	newKeyfile := []byte("the keyfile of the new password")
+	recovered, err := RecoverVault(vault, recoverySlot, vault.vaultKey, Credentials{Password: newPassword, Keyfile: newKeyfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !MasterNeedsKeyfile(recovered) {
+		t.Error("the new master password doesn't need a keyfile")
+	}
+	if vaultKey, _, err := UnlockVault(recovered, Credentials{Password: newPassword}); err == nil {
+		vaultKey.Destroy()
+		t.Error("the new master password opens the vault without its keyfile")
+	}
+	vaultKey, _, err := UnlockVault(recovered, Credentials{Password: newPassword, Keyfile: newKeyfile})
+	if err != nil {
+		t.Fatalf("UnlockVault() with the new password and keyfile = %v", err)
+	}
+	vaultKey.Destroy()
+}
@@ -0,0 +1,134 @@
+package main
+
+import "sync"
+
+// LockedBuffer holds key material or a revealed secret. Its memory is locked
+// so it isn't swapped out, and it is zeroed when the buffer is destroyed.
+// Buffers decrypted with a vault key belong to it, so locking the vault wipes
+// them along with the key and leaves the buffers of other work alone. Every
+// live buffer is remembered so all of them can be wiped when the program
+// exits. The memory is given back when the buffer is destroyed, so the buffer
+// is what gets passed around, not slices of it: a slice kept past Destroy
+// points at memory that's gone.
+type LockedBuffer struct {
+	data   []byte
+	locked bool
+	// owner is the vault key the contents were decrypted with, nil for keys
+	owner *LockedBuffer
+}
+
+var (
+	lockedBuffersMu sync.Mutex
+	lockedBuffers   = map[*LockedBuffer]struct{}{}
+)
+
+// NewLockedBuffer allocates a zeroed buffer of size bytes on pages of its
+// own, outside the Go heap. If that or locking isn't allowed the buffer comes
+// from the heap and is still wiped on destroy.
+func NewLockedBuffer(size int) *LockedBuffer {
+	b := &LockedBuffer{}
+	if size == 0 {
+		b.data = []byte{}
+		return b
+	}
+	data, err := allocLocked(size)
+	if err != nil {
+		data = make([]byte, size)
+	}
+	b.data = data
+	b.locked = err == nil
+
+	lockedBuffersMu.Lock()
+	lockedBuffers[b] = struct{}{}
+	lockedBuffersMu.Unlock()
+	return b
+}
+
+// LockBytes moves b into a new locked buffer and zeroes b.
+func LockBytes(b []byte) *LockedBuffer {
+	buf := NewLockedBuffer(len(b))
+	copy(buf.data, b)
+	wipeBytes(b)
+	return buf
+}
+
+// ownedBy makes b belong to vaultKey, see DestroyVaultBuffers.
+func (b *LockedBuffer) ownedBy(vaultKey *LockedBuffer) *LockedBuffer {
+	lockedBuffersMu.Lock()
+	b.owner = vaultKey
+	lockedBuffersMu.Unlock()
+	return b
+}
+
+// Bytes returns the contents of the buffer, nil once it is destroyed. The
+// slice points into the locked memory and must not be kept past Destroy.
+func (b *LockedBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	return b.data
+}
+
+// String returns a copy of the contents for display. The copy is an ordinary
+// Go string the buffer can't wipe, so it should only be made for rendering.
+func (b *LockedBuffer) String() string {
+	if b == nil {
+		return ""
+	}
+	return string(b.data)
+}
+
+// Destroy zeroes the buffer and gives its memory back. It is safe to call
+// more than once.
+func (b *LockedBuffer) Destroy() {
+	if b == nil || b.data == nil {
+		return
+	}
+	lockedBuffersMu.Lock()
+	delete(lockedBuffers, b)
+	lockedBuffersMu.Unlock()
+	b.destroy()
+}
+
+func (b *LockedBuffer) destroy() {
+	wipeBytes(b.data)
+	if b.locked {
+		freeLocked(b.data)
+		b.locked = false
+	}
+	b.data = nil
+}
+
+// DestroyVaultBuffers wipes the key of a vault that is being locked and every
+// buffer decrypted with it.
+func DestroyVaultBuffers(vaultKey *LockedBuffer) {
+	if vaultKey == nil {
+		return
+	}
+	lockedBuffersMu.Lock()
+	for b := range lockedBuffers {
+		if b.owner == vaultKey {
+			b.destroy()
+			delete(lockedBuffers, b)
+		}
+	}
+	lockedBuffersMu.Unlock()
+	vaultKey.Destroy()
+}
+
+// DestroyLockedBuffers wipes every live locked buffer. It is only for the
+// exit, once nothing runs that could still use one, see stopBackground.
+func DestroyLockedBuffers() {
+	lockedBuffersMu.Lock()
+	defer lockedBuffersMu.Unlock()
+	for b := range lockedBuffers {
+		b.destroy()
+		delete(lockedBuffers, b)
+	}
+}
+
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
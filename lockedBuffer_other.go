@@ -0,0 +1,19 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// Memory locking isn't available here, buffers are only wiped.
+
+func allocLocked(size int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func freeLocked(b []byte) error {
+	return nil
+}
+
+func disableCoreDumps() error {
+	return nil
+}
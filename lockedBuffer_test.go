@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestDestroyVaultBuffers(t *testing.T) {
+	locked, other := LockBytes([]byte("key of the locked vault")), LockBytes([]byte("key of another vault"))
+	defer other.Destroy()
+	revealed := LockBytes([]byte("revealed secret")).ownedBy(locked)
+	otherRevealed := LockBytes([]byte("secret of another vault")).ownedBy(other)
+	defer otherRevealed.Destroy()
+	// Like a secret decrypted during a rotation
+	working := LockBytes([]byte("secret being re-encrypted"))
+	defer working.Destroy()
+
+	DestroyVaultBuffers(locked)
+	for name, b := range map[string]*LockedBuffer{"vault key": locked, "revealed secret": revealed} {
+		if b.Bytes() != nil {
+			t.Errorf("%s of the locked vault left", name)
+		}
+	}
+	for name, b := range map[string]*LockedBuffer{"other vault key": other, "other secret": otherRevealed, "rotation buffer": working} {
+		if b.Bytes() == nil {
+			t.Errorf("%s wiped along with the locked vault", name)
+		}
+	}
+}
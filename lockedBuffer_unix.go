@@ -0,0 +1,38 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocLocked maps pages of their own for a buffer of size bytes and locks
+// them. Locks don't nest, so buffers sharing a page would unlock each other.
+func allocLocked(size int) ([]byte, error) {
+	pageSize := os.Getpagesize()
+	mapped, err := unix.Mmap(-1, 0, (size+pageSize-1)/pageSize*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Mlock(mapped); err != nil {
+		unix.Munmap(mapped)
+		return nil, err
+	}
+	return mapped[:size], nil
+}
+
+// freeLocked unlocks and unmaps what allocLocked returned.
+func freeLocked(b []byte) error {
+	b = b[:cap(b)]
+	if err := unix.Munlock(b); err != nil {
+		return err
+	}
+	return unix.Munmap(b)
+}
+
+// disableCoreDumps keeps a crash from writing keys and secrets to disk.
+func disableCoreDumps() error {
+	return unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{Cur: 0, Max: 0})
+}
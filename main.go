@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -14,15 +19,76 @@ const (
 	enterVaultView
 	vaultView
 	createSecretView
+	changePasswordView
+	rotateKeyView
+	keySlotsView
+	recoveryCodesView
+	generatorView
+	conflictView
 )
 
-const VAULTSPATH = "vaults/"
-
 var Program *tea.Program
 
+// Commands that use locked buffers outside of Update, like a key rotation,
+// run as background work. Quitting cancels it and waits for it to return
+// before the buffers are wiped, so none is unmapped while still in use.
+var (
+	backgroundMu                    sync.Mutex
+	backgroundStopped               bool
+	backgroundWork                  sync.WaitGroup
+	backgroundCtx, cancelBackground = context.WithCancel(context.Background())
+)
+
+// startBackground counts a piece of background work, which calls
+// backgroundWork.Done when it returns. It reports false once the program is
+// quitting, the work mustn't start then.
+func startBackground() bool {
+	backgroundMu.Lock()
+	defer backgroundMu.Unlock()
+	if backgroundStopped {
+		return false
+	}
+	backgroundWork.Add(1)
+	return true
+}
+
+// stopBackground cancels the background work and waits until all of it
+// returned.
+func stopBackground() {
+	backgroundMu.Lock()
+	backgroundStopped = true
+	backgroundMu.Unlock()
+	cancelBackground()
+	backgroundWork.Wait()
+}
+
 func main() {
+	// Keys and secrets in memory must not end up in a core dump
+	if err := disableCoreDumps(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: couldn't disable core dumps: %v\n", err)
+	}
+	args, dir, err := parseGlobalFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Print(cliUsage)
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%s", cliUsage)
+		os.Exit(2)
+	}
+	if err := setupVaultsDir(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: can't use the vaults directory: %v\n", err)
+		os.Exit(1)
+	}
+	if len(args) > 0 {
+		os.Exit(runCLI(args))
+	}
+
 	Program = tea.NewProgram(initialMainModel())
-	if _, err := Program.Run(); err != nil {
+	_, err = Program.Run()
+	stopBackground()
+	DestroyLockedBuffers()
+	ClearClipboard()
+	if err != nil {
 		fmt.Printf("There is been an error: %v", err)
 		os.Exit(1)
 	}
@@ -36,6 +102,21 @@ type mainModel struct {
 	enterVaultView   tea.Model
 	vaultView        tea.Model
 	createSecretView tea.Model
+
+	changePasswordView tea.Model
+	rotateKeyView      tea.Model
+	keySlotsView       tea.Model
+	recoveryCodesView  tea.Model
+	generatorView      tea.Model
+	conflictView       tea.Model
+
+	// Idle timer of the open vault, see autoLock.go
+	autoLockGeneration int
+	lastActivity       time.Time
+	// Countdown of the clipboard clear, see clipboard.go
+	clipboardGeneration int
+	// Pause in typing before the strength meter updates, see strengthTick
+	strengthGeneration int
 }
 
 func (m mainModel) Init() tea.Cmd {
@@ -62,6 +143,24 @@ func (m mainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case createSecretView:
 		model, cmd := m.createSecretView.Update(msg)
 		return model, cmd
+	case changePasswordView:
+		model, cmd := m.changePasswordView.Update(msg)
+		return model, cmd
+	case rotateKeyView:
+		model, cmd := m.rotateKeyView.Update(msg)
+		return model, cmd
+	case keySlotsView:
+		model, cmd := m.keySlotsView.Update(msg)
+		return model, cmd
+	case recoveryCodesView:
+		model, cmd := m.recoveryCodesView.Update(msg)
+		return model, cmd
+	case generatorView:
+		model, cmd := m.generatorView.Update(msg)
+		return model, cmd
+	case conflictView:
+		model, cmd := m.conflictView.Update(msg)
+		return model, cmd
 
 	}
 }
@@ -80,6 +179,18 @@ func (m mainModel) View() string {
 		return m.vaultView.View()
 	case createSecretView:
 		return m.createSecretView.View()
+	case changePasswordView:
+		return m.changePasswordView.View()
+	case rotateKeyView:
+		return m.rotateKeyView.View()
+	case keySlotsView:
+		return m.keySlotsView.View()
+	case recoveryCodesView:
+		return m.recoveryCodesView.View()
+	case generatorView:
+		return m.generatorView.View()
+	case conflictView:
+		return m.conflictView.View()
 	}
 }
 
@@ -92,7 +203,14 @@ func initialMainModel() mainModel {
 		createVaultView:  InitialCreateVaultModel(&m),
 		enterVaultView:   InitialEnterVaultModel(&m),
 		vaultView:        InitialVaultModel(&m),
-		createSecretView: InitialCreateSecretModel(&m)}
+		createSecretView: InitialCreateSecretModel(&m),
+
+		changePasswordView: InitialChangePasswordModel(&m),
+		rotateKeyView:      InitialRotateKeyModel(&m),
+		keySlotsView:       InitialKeySlotsModel(&m),
+		recoveryCodesView:  InitialRecoveryCodesModel(&m),
+		generatorView:      InitialGeneratorModel(&m),
+		conflictView:       InitialConflictModel(&m)}
 
 	return m
 }
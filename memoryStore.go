@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// MemoryStore keeps the vaults in memory only, for tests and trying things
+// out. Nothing survives the process.
+type MemoryStore struct {
+	mu      sync.Mutex
+	vaults  map[string][]byte
+	backups map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{vaults: map[string][]byte{}, backups: map[string][]byte{}}
+}
+
+func (s *MemoryStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.vaults)), nil
+}
+
+func (s *MemoryStore) Load(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vaultByte, ok := s.vaults[name]
+	if !ok {
+		return nil, notStored(name)
+	}
+	return slices.Clone(vaultByte), nil
+}
+
+func (s *MemoryStore) Save(name string, data []byte, revision uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.vaults[name]; ok {
+		if err := checkStoredRevision(current, nil, revision); err != nil {
+			return err
+		}
+	}
+	s.vaults[name] = slices.Clone(data)
+	return nil
+}
+
+func (s *MemoryStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.vaults[name]; !ok {
+		return notStored(name)
+	}
+	delete(s.vaults, name)
+	s.dropCopies(name)
+	return nil
+}
+
+func (s *MemoryStore) Backup(name string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vaultByte, ok := s.vaults[name]
+	if !ok {
+		return notStored(name)
+	}
+	key := fmt.Sprintf("%s.v%d", name, version)
+	if _, ok := s.backups[key]; !ok {
+		s.backups[key] = vaultByte
+	}
+	return nil
+}
+
+func (s *MemoryStore) DropCopies(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropCopies(name)
+	return nil
+}
+
+func (s *MemoryStore) dropCopies(name string) {
+	for key := range s.backups {
+		if isBackupOf(key, name) {
+			delete(s.backups, key)
+		}
+	}
+}
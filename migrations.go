@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Vault file format versions:
+//
+//	1  files without a FormatVersion field
+//	2  vaults and secrets have IDs and secrets are authenticated with them
+//	3  the whole file is authenticated with a MAC and has a revision
+const currentFormatVersion = 3
+
+// A migration upgrades an unlocked vault from version to-1 to version to.
+// Migrations get the vault in memory and must not save it themselves.
+type migration struct {
+	to          int
+	description string
+	migrate     func(vault Vault, vaultKey []byte) (Vault, error)
+}
+
+// migrations are run in order, each one only on vaults older than its
+// version. A new format version needs a migration here.
+var migrations = []migration{
+	{to: 2, description: "bind secrets to their vault", migrate: migrateBindSecrets},
+	{to: 3, description: "authenticate the whole file", migrate: migrateAddMAC},
+}
+
+// ErrFutureVersion is returned for vault files written by a newer ciphery.
+var ErrFutureVersion = errors.New("vault file is from a newer version of ciphery")
+
+// checkFormatVersion reads only the format version of a vault file, so files
+// from a newer version are refused before anything else of them is parsed.
+func checkFormatVersion(vaultByte []byte) error {
+	var header struct {
+		FormatVersion int `json:"FormatVersion"`
+	}
+	if err := json.Unmarshal(vaultByte, &header); err != nil {
+		return err
+	}
+	if header.FormatVersion > currentFormatVersion {
+		return fmt.Errorf("%w: it has format %d but this one only knows up to %d, update ciphery to open it", ErrFutureVersion, header.FormatVersion, currentFormatVersion)
+	}
+	return nil
+}
+
+// migrateVault brings an unlocked vault up to the current format. Nothing is
+// saved, the returned vault is the migrated copy.
+func migrateVault(vault Vault, vaultKey []byte) (Vault, error) {
+	migrated := vault
+	for _, m := range migrations {
+		if migrated.FormatVersion >= m.to {
+			continue
+		}
+		var err error
+		migrated, err = runMigration(m, migrated, vaultKey)
+		if err != nil {
+			return vault, err
+		}
+	}
+	return migrated, nil
+}
+
+func runMigration(m migration, vault Vault, vaultKey []byte) (Vault, error) {
+	migrated, err := m.migrate(vault, vaultKey)
+	if err != nil {
+		return vault, fmt.Errorf("migrating to format %d (%s): %w", m.to, m.description, err)
+	}
+	migrated.FormatVersion = m.to
+	return migrated, nil
+}
+
+// MigrateVault migrates an unlocked vault to the current format one version
+// at a time. Before each step the file as it is is copied to a backup next to
+// it, and after each step the vault is saved, so a failure leaves a vault in
+// a known version behind. Once the migrated vault reads back fine the backups
+// are removed: they hold the key slots as they were, legacy key derivation
+// included, which would otherwise stay open to brute force.
+func MigrateVault(vault Vault, vaultKey *LockedBuffer) (Vault, error) {
+	if vault.FormatVersion >= currentFormatVersion {
+		return vault, nil
+	}
+
+	current, err := OpenVault(vault, vaultKey)
+	if err != nil {
+		return vault, err
+	}
+	for _, m := range migrations {
+		if current.FormatVersion >= m.to {
+			continue
+		}
+		if err := store.Backup(current.FileName(), current.FormatVersion); err != nil {
+			return vault, fmt.Errorf("backing up before migrating to format %d: %w", m.to, err)
+		}
+		migrated, err := runMigration(m, current, vaultKey.Bytes())
+		if err != nil {
+			return vault, err
+		}
+		current, err = SaveVault(migrated)
+		if err != nil {
+			return vault, err
+		}
+	}
+
+	if err := verifyMigrated(current.FileName(), vaultKey); err != nil {
+		return current, fmt.Errorf("migrated vault doesn't read back, the backups are kept: %w", err)
+	}
+	if err := store.DropCopies(current.FileName()); err != nil {
+		return current, fmt.Errorf("removing the backups of the migrated vault: %w", err)
+	}
+	return current, nil
+}
+
+// verifyMigrated reads a migrated vault back from the store, checks it's
+// intact and decrypts every secret.
+func verifyMigrated(fileName string, vaultKey *LockedBuffer) error {
+	stored, err := LoadVault(fileName)
+	if err != nil {
+		return err
+	}
+	if err := VerifyVault(stored, vaultKey.Bytes()); err != nil {
+		return err
+	}
+	opened, err := OpenVault(stored, vaultKey)
+	if err != nil {
+		return err
+	}
+	for i, secret := range opened.Secrets {
+		secretName, secretText, err := decryptSecretFields(secret.EncodedEncryptedName, secret.EncodedEncryptedText, vaultKey.Bytes(), secretAAD(opened, secret.ID), secret.Cipher)
+		if err != nil {
+			return fmt.Errorf("secret %d: %w", i+1, err)
+		}
+		wipeBytes(secretName)
+		wipeBytes(secretText)
+	}
+	return nil
+}
+
+// migrateBindSecrets gives the vault and its secrets IDs and encrypts every
+// secret again with associated data that binds it to them.
+func migrateBindSecrets(vault Vault, vaultKey []byte) (Vault, error) {
+	var err error
+	migrated := vault
+	migrated.FormatVersion = 2
+	if migrated.ID == "" {
+		migrated.ID, err = newVaultID()
+		if err != nil {
+			return vault, err
+		}
+	}
+
+	migrated.Secrets = make([]Secret, len(vault.Secrets))
+	for i, secret := range vault.Secrets {
+		secretID, err := newSecretID()
+		if err != nil {
+			return vault, err
+		}
+		migrated.Secrets[i], err = reencryptSecret(vault, migrated, secret, secretID, vaultKey, vaultKey)
+		if err != nil {
+			return vault, fmt.Errorf("secret %d: %w", i+1, err)
+		}
+	}
+	return migrated, nil
+}
+
+// migrateAddMAC changes nothing but the version, the MAC and the revision are
+// written when the vault is saved.
+func migrateAddMAC(vault Vault, vaultKey []byte) (Vault, error) {
+	return vault, nil
+}
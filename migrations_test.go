@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var testSecrets = [][2]string{
+	{"mail", "hunter2"},
+	{"bank", "correct horse battery staple"},
+}
+
+// vaultAtVersion returns an open vault in format version holding
+// testSecrets, encrypted the way that version did.
+func vaultAtVersion(t *testing.T, version int, vaultKey *LockedBuffer) Vault {
+	t.Helper()
+	creds := Credentials{Password: "old vault"}
+	slot, err := NewKeySlot(slotPassword, "master password", creds.secretFor(KeySlot{Type: slotPassword}), vaultKey.Bytes(), cipherAES256GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault := Vault{FormatVersion: version, Name: "old", KeySlots: []KeySlot{slot}, vaultKey: vaultKey}
+	if version >= 2 {
+		vault.ID, _ = newVaultID()
+	}
+	for _, plain := range testSecrets {
+		secretID := ""
+		if version >= 2 {
+			secretID, _ = newSecretID()
+		}
+		secret, err := EncryptSecret(vault, secretID, plain[0], plain[1], vaultKey.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		vault.Secrets = append(vault.Secrets, secret)
+	}
+	return vault
+}
+
+func checkTestSecrets(t *testing.T, vault Vault, vaultKey *LockedBuffer) {
+	t.Helper()
+	if len(vault.Secrets) != len(testSecrets) {
+		t.Fatalf("%d secrets, want %d", len(vault.Secrets), len(testSecrets))
+	}
+	for i, secret := range vault.Secrets {
+		secretName, secretText, err := DecryptSecret(vault, secret, vaultKey.Bytes())
+		if err != nil {
+			t.Fatalf("secret %d: %v", i+1, err)
+		}
+		if secretName != testSecrets[i][0] || secretText != testSecrets[i][1] {
+			t.Errorf("secret %d is %q/%q, want %q/%q", i+1, secretName, secretText, testSecrets[i][0], testSecrets[i][1])
+		}
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	tests := []struct {
+		to    int
+		check func(t *testing.T, before, after Vault)
+	}{
+		{
+			to: 2,
+			check: func(t *testing.T, before, after Vault) {
+				if after.ID == "" {
+					t.Error("vault has no ID")
+				}
+				seen := map[string]bool{}
+				for i, secret := range after.Secrets {
+					if secret.ID == "" || seen[secret.ID] {
+						t.Errorf("secret %d has ID %q", i+1, secret.ID)
+					}
+					seen[secret.ID] = true
+				}
+			},
+		},
+		{
+			to: 3,
+			check: func(t *testing.T, before, after Vault) {
+				if after.ID != before.ID {
+					t.Errorf("ID changed from %q to %q", before.ID, after.ID)
+				}
+				for i := range after.Secrets {
+					if after.Secrets[i] != before.Secrets[i] {
+						t.Errorf("secret %d changed", i+1)
+					}
+				}
+			},
+		},
+	}
+	if len(tests) != len(migrations) {
+		t.Fatalf("%d migrations but %d test cases, every migration needs one", len(migrations), len(tests))
+	}
+	for i, tt := range tests {
+		m := migrations[i]
+		if m.to != tt.to {
+			t.Fatalf("migration %d goes to format %d, test case to %d", i, m.to, tt.to)
+		}
+		t.Run(m.description, func(t *testing.T) {
+			vaultKey, err := generateVaultKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer vaultKey.Destroy()
+			before := vaultAtVersion(t, m.to-1, vaultKey)
+
+			after, err := runMigration(m, before, vaultKey.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.FormatVersion != m.to {
+				t.Errorf("format version %d, want %d", after.FormatVersion, m.to)
+			}
+			checkTestSecrets(t, after, vaultKey)
+			tt.check(t, before, after)
+		})
+	}
+}
+
+func TestMigrateVault(t *testing.T) {
+	memory := useMemoryStore(t)
+	vaultKey, err := generateVaultKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vaultKey.Destroy()
+	oldVault := vaultAtVersion(t, 1, vaultKey)
+	// Format 1 files didn't have the field
+	oldVault.FormatVersion = 0
+	vaultByte, err := json.Marshal(oldVault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory.vaults["old"] = vaultByte
+
+	loaded, err := LoadVault("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyVault(loaded, vaultKey.Bytes()); err != nil {
+		t.Fatalf("VerifyVault() of the old file = %v", err)
+	}
+	migrated, err := MigrateVault(loaded, vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.FormatVersion != currentFormatVersion {
+		t.Errorf("format version %d, want %d", migrated.FormatVersion, currentFormatVersion)
+	}
+	checkTestSecrets(t, migrated, vaultKey)
+
+	stored, err := LoadVault("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyVault(stored, vaultKey.Bytes()); err != nil {
+		t.Errorf("VerifyVault() of the migrated file = %v", err)
+	}
+	// The backups still open with the old slots, they go once the migrated
+	// vault reads back
+	if len(memory.backups) != 0 {
+		t.Errorf("backups left after migrating: %d", len(memory.backups))
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// The generator makes either random characters or a diceware passphrase of
+// random words from the EFF large word list. Everything is picked with
+// crypto/rand, and the entropy reported is exact for the options given.
+
+//go:embed wordlists/eff_large_wordlist.txt
+var effWordList string
+
+// Generator modes.
+const (
+	generateCharacters = iota
+	generatePassphrase
+)
+
+var generatorModeNames = []string{
+	generateCharacters: "characters",
+	generatePassphrase: "passphrase",
+}
+
+// Character classes of the character mode. Symbols leave out quotes, slashes
+// and backslashes, which are awkward to type in shells and forms.
+const (
+	lowerChars     = "abcdefghijklmnopqrstuvwxyz"
+	upperChars     = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars     = "0123456789"
+	symbolChars    = "!#$%&()*+,-.:;<=>?@[]^_{}~"
+	ambiguousChars = "0Oo1lI|"
+)
+
+// Capitalization of passphrase words.
+const (
+	capitalizeNone = iota
+	capitalizeFirst
+	capitalizeAll
+	capitalizeRandom
+)
+
+var capitalizationNames = []string{
+	capitalizeNone:   "lowercase",
+	capitalizeFirst:  "Capitalized",
+	capitalizeAll:    "UPPERCASE",
+	capitalizeRandom: "Random",
+}
+
+// Separators passphrase words can be joined with.
+var passphraseSeparators = []string{"-", " ", ".", "_", ",", ""}
+
+// Limits of the generator options. 12 words of the EFF list always fit into
+// a secret.
+const (
+	minGeneratedLength = 4
+	maxGeneratedLength = 128
+	minPassphraseWords = 3
+	maxPassphraseWords = 12
+)
+
+// GeneratorOptions are the settings of the password generator.
+type GeneratorOptions struct {
+	Mode int
+
+	Length           int
+	Lower            bool
+	Upper            bool
+	Digits           bool
+	Symbols          bool
+	ExcludeAmbiguous bool
+	// RequireEach asks for at least one character of every class used.
+	RequireEach bool
+
+	Words          int
+	Separator      string
+	Capitalization int
+}
+
+// DefaultGeneratorOptions are used until something else is chosen.
+func DefaultGeneratorOptions() GeneratorOptions {
+	return GeneratorOptions{
+		Mode:        generateCharacters,
+		Length:      20,
+		Lower:       true,
+		Upper:       true,
+		Digits:      true,
+		Symbols:     true,
+		RequireEach: true,
+		Words:       6,
+		Separator:   "-",
+	}
+}
+
+var ErrNoCharacterClass = errors.New("choose at least one character class")
+
+// Generate returns a new password or passphrase and its entropy in bits.
+func Generate(options GeneratorOptions) (string, float64, error) {
+	if options.Mode == generatePassphrase {
+		return generatePassphraseWords(options)
+	}
+	return generateCharacterPassword(options)
+}
+
+// characterClasses returns the characters of each class the options use.
+func (options GeneratorOptions) characterClasses() []string {
+	var classes []string
+	for _, class := range []struct {
+		used  bool
+		chars string
+	}{
+		{options.Lower, lowerChars},
+		{options.Upper, upperChars},
+		{options.Digits, digitChars},
+		{options.Symbols, symbolChars},
+	} {
+		if !class.used {
+			continue
+		}
+		chars := class.chars
+		if options.ExcludeAmbiguous {
+			chars = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(ambiguousChars, r) {
+					return -1
+				}
+				return r
+			}, chars)
+		}
+		classes = append(classes, chars)
+	}
+	return classes
+}
+
+func generateCharacterPassword(options GeneratorOptions) (string, float64, error) {
+	if options.Length < minGeneratedLength || options.Length > maxGeneratedLength {
+		return "", 0, fmt.Errorf("length must be between %d and %d", minGeneratedLength, maxGeneratedLength)
+	}
+	classes := options.characterClasses()
+	if len(classes) == 0 {
+		return "", 0, ErrNoCharacterClass
+	}
+	alphabet := []rune(strings.Join(classes, ""))
+
+	// Passwords missing a required class are thrown away and drawn again, so
+	// every password that has all of them is equally likely
+	password := make([]rune, options.Length)
+	for {
+		for i := range password {
+			n, err := randomIndex(len(alphabet))
+			if err != nil {
+				return "", 0, err
+			}
+			password[i] = alphabet[n]
+		}
+		if !options.RequireEach || hasEveryClass(string(password), classes) {
+			break
+		}
+	}
+	return string(password), characterPasswordBits(len(alphabet), classes, options), nil
+}
+
+func hasEveryClass(password string, classes []string) bool {
+	for _, class := range classes {
+		if !strings.ContainsAny(password, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// characterPasswordBits counts the passwords the options can produce. With
+// every class required, the ones missing a class are taken out by inclusion
+// and exclusion.
+func characterPasswordBits(alphabetSize int, classes []string, options GeneratorOptions) float64 {
+	length := float64(options.Length)
+	if !options.RequireEach {
+		return length * math.Log2(float64(alphabetSize))
+	}
+
+	count := 0.0
+	for subset := 0; subset < 1<<len(classes); subset++ {
+		left, sign := alphabetSize, 1.0
+		for i, class := range classes {
+			if subset&(1<<i) != 0 {
+				left -= len(class)
+				sign = -sign
+			}
+		}
+		count += sign * math.Pow(float64(left), length)
+	}
+	return math.Log2(count)
+}
+
+var (
+	effWordsOnce sync.Once
+	effWords     []string
+)
+
+// loadEFFWords reads the words of the EFF list, leaving out the dice rolls.
+func loadEFFWords() {
+	for _, line := range strings.Split(strings.TrimSpace(effWordList), "\n") {
+		if _, word, ok := strings.Cut(line, "\t"); ok {
+			effWords = append(effWords, word)
+		}
+	}
+}
+
+func generatePassphraseWords(options GeneratorOptions) (string, float64, error) {
+	if options.Words < minPassphraseWords || options.Words > maxPassphraseWords {
+		return "", 0, fmt.Errorf("a passphrase has between %d and %d words", minPassphraseWords, maxPassphraseWords)
+	}
+	effWordsOnce.Do(loadEFFWords)
+
+	words := make([]string, options.Words)
+	bits := float64(options.Words) * math.Log2(float64(len(effWords)))
+	for i := range words {
+		n, err := randomIndex(len(effWords))
+		if err != nil {
+			return "", 0, err
+		}
+		word := effWords[n]
+
+		switch options.Capitalization {
+		case capitalizeFirst:
+			word = capitalizeWord(word)
+		case capitalizeAll:
+			word = strings.ToUpper(word)
+		case capitalizeRandom:
+			coin, err := randomIndex(2)
+			if err != nil {
+				return "", 0, err
+			}
+			if coin == 1 {
+				word = capitalizeWord(word)
+			}
+			bits++
+		}
+		words[i] = word
+	}
+	return strings.Join(words, options.Separator), bits, nil
+}
+
+func capitalizeWord(word string) string {
+	runes := []rune(word)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// randomIndex returns a uniformly random number from 0 up to n.
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
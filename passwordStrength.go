@@ -0,0 +1,805 @@
+package main
+
+import (
+	_ "embed"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Passwords are scored the way they would be guessed: common passwords and
+// words first, then rows of keys, repeats, sequences and dates, and only then
+// character by character. The password is split into the parts that are
+// cheapest to guess and their entropy is added up.
+
+//go:embed wordlists/passwords.txt
+var commonPasswordsList string
+
+//go:embed wordlists/english.txt
+var englishWordsList string
+
+//go:embed wordlists/names.txt
+var namesList string
+
+// Strength scores of a password.
+const (
+	scoreVeryWeak = iota
+	scoreWeak
+	scoreFair
+	scoreStrong
+	scoreVeryStrong
+)
+
+var scoreNames = []string{
+	scoreVeryWeak:   "very weak",
+	scoreWeak:       "weak",
+	scoreFair:       "fair",
+	scoreStrong:     "strong",
+	scoreVeryStrong: "very strong",
+}
+
+// scoreBits is the entropy a password needs to reach each score.
+var scoreBits = []float64{
+	scoreVeryWeak:   0,
+	scoreWeak:       25,
+	scoreFair:       40,
+	scoreStrong:     60,
+	scoreVeryStrong: 80,
+}
+
+// defaultMinPasswordScore is the minimum strength new vaults ask of their
+// master passwords.
+const defaultMinPasswordScore = scoreFair
+
+// Only the start of very long passwords is looked at closely. The rest counts
+// no more per character than the start, so padding a guessable password
+// doesn't make it strong, and at most as much as the start in total.
+const maxAnalyzedRunes = 100
+
+// PasswordStrength is the estimated strength of a password. Weak ones come
+// with a warning about what makes them weak and suggestions.
+type PasswordStrength struct {
+	Score       int
+	Bits        float64
+	Warning     string
+	Suggestions []string
+}
+
+// SetMinPasswordScore changes the weakest master password an open vault
+// accepts and saves it.
+func SetMinPasswordScore(vault Vault, score int) (Vault, error) {
+	changed := vault
+	changed.MinPasswordScore = score
+	saved, err := SaveVault(changed)
+	if err != nil {
+		return vault, err
+	}
+	return saved, nil
+}
+
+// EstimateStrength estimates how hard password is to guess.
+func EstimateStrength(password string) PasswordStrength {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return PasswordStrength{}
+	}
+
+	analyzed := runes[:min(len(runes), maxAnalyzedRunes)]
+	a := strengthAnalysis{memo: make(map[string]float64)}
+	bits, used := a.bits(analyzed)
+	if rest := len(runes) - len(analyzed); rest > 0 {
+		bits += min(float64(rest)*bits/float64(len(analyzed)), bits)
+	}
+
+	strength := PasswordStrength{Bits: bits, Score: scoreForBits(bits)}
+	if strength.Score < scoreStrong {
+		strength.Warning, strength.Suggestions = strengthFeedback(runes, used)
+	}
+	return strength
+}
+
+// scoreForBits returns the score of a password that takes bits to guess.
+func scoreForBits(bits float64) int {
+	score := scoreVeryWeak
+	for s := range scoreBits {
+		if bits >= scoreBits[s] {
+			score = s
+		}
+	}
+	return score
+}
+
+// Kinds of guessable parts found in a password.
+const (
+	matchPassword = iota
+	matchWord
+	matchKeyboard
+	matchRepeat
+	matchSequence
+	matchDate
+)
+
+// strengthMatch is a guessable part of a password, the runes from start up
+// to end, and how many bits it takes to guess.
+type strengthMatch struct {
+	start, end int
+	bits       float64
+	kind       int
+
+	rank        int
+	capitalized bool
+	l33t        bool
+	reversed    bool
+	misspelled  bool
+}
+
+type strengthAnalysis struct {
+	// memo holds the bits of parts that were estimated already, repeats
+	// estimate what they repeat.
+	memo map[string]float64
+}
+
+// bits returns the fewest bits needed to guess password and the matches that
+// make it up.
+func (a *strengthAnalysis) bits(password []rune) (float64, []strengthMatch) {
+	var matches []strengthMatch
+	matches = append(matches, dictionaryMatches(password)...)
+	matches = append(matches, keyboardMatches(password)...)
+	matches = append(matches, a.repeatMatches(password)...)
+	// A date like 19871231 is a date, even though 987 and 123 in it are
+	// sequences
+	dates := dateMatches(password)
+	matches = append(matches, withoutCovered(sequenceMatches(password), dates)...)
+	matches = append(matches, dates...)
+
+	perRune := bruteForceBits(password)
+	best := make([]float64, len(password)+1)
+	via := make([]int, len(password)+1)
+	for end := 1; end <= len(password); end++ {
+		best[end] = best[end-1] + perRune
+		via[end] = -1
+		for i, m := range matches {
+			if m.end == end && best[m.start]+m.bits < best[end] {
+				best[end] = best[m.start] + m.bits
+				via[end] = i
+			}
+		}
+	}
+
+	var used []strengthMatch
+	for end := len(password); end > 0; {
+		if via[end] < 0 {
+			end--
+			continue
+		}
+		m := matches[via[end]]
+		used = append(used, m)
+		end = m.start
+	}
+	return best[len(password)], used
+}
+
+// withoutCovered drops the matches that lie within one of by.
+func withoutCovered(matches, by []strengthMatch) []strengthMatch {
+	return slices.DeleteFunc(matches, func(m strengthMatch) bool {
+		return slices.ContainsFunc(by, func(b strengthMatch) bool {
+			return b.start <= m.start && m.end <= b.end
+		})
+	})
+}
+
+// bruteForceBits is what guessing one character of password costs when
+// trying every character of the classes it uses.
+func bruteForceBits(password []rune) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	cardinality := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			cardinality += class.size
+		}
+	}
+	return math.Log2(float64(cardinality))
+}
+
+// variationBits is what guessing which of total characters were changed,
+// like capitalized, costs when changed of them were.
+func variationBits(total, changed int) float64 {
+	if changed == 0 {
+		return 0
+	}
+	if changed == total {
+		return 1
+	}
+	variations := 0.0
+	for k := 1; k <= min(changed, total-changed); k++ {
+		variations += binomial(total, k)
+	}
+	return math.Log2(variations)
+}
+
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+// Dictionary words and common passwords
+
+type dictionaryWord struct {
+	rank     int
+	password bool
+}
+
+var (
+	dictionaryOnce sync.Once
+	dictionary     map[string]dictionaryWord
+	longestWord    int
+)
+
+// loadDictionary reads the embedded word lists. Words in more than one list
+// keep their best rank.
+func loadDictionary() {
+	dictionary = make(map[string]dictionaryWord)
+	add := func(list string, password bool) {
+		for i, word := range strings.Fields(list) {
+			if known, ok := dictionary[word]; ok && known.rank <= i+1 {
+				continue
+			}
+			dictionary[word] = dictionaryWord{rank: i + 1, password: password}
+			longestWord = max(longestWord, utf8.RuneCountInString(word))
+		}
+	}
+	add(commonPasswordsList, true)
+	add(englishWordsList, false)
+	add(namesList, false)
+}
+
+// l33tTable maps symbols people put in place of letters back to them. '1'
+// stands for both i and l and is handled on its own.
+var l33tTable = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '{': 'c', '3': 'e', '6': 'g', '9': 'g',
+	'!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+func dictionaryMatches(password []rune) []strengthMatch {
+	dictionaryOnce.Do(loadDictionary)
+
+	lower := make([]rune, len(password))
+	for i, r := range password {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var matches []strengthMatch
+	for start := range lower {
+		for end := start + 3; end <= len(lower) && end-start <= longestWord; end++ {
+			part := lower[start:end]
+			upper := countUpper(password[start:end])
+			caseBits := variationBits(len(part), upper)
+			if upper == 1 && unicode.IsUpper(password[start]) {
+				// A capital first letter is the first thing tried
+				caseBits = 1
+			}
+			match := func(word string, extraBits float64, l33t, reversed bool) {
+				known, ok := dictionary[word]
+				misspelled := false
+				if !ok && !reversed {
+					// One letter left out, like troubador for troubadour.
+					// Guessing which one costs a guess per letter.
+					var near string
+					if near, known, ok = misspelledWord(word); ok {
+						extraBits += math.Log2(float64(utf8.RuneCountInString(near)))
+						misspelled = true
+					}
+				}
+				if !ok {
+					return
+				}
+				kind := matchWord
+				if known.password {
+					kind = matchPassword
+				}
+				matches = append(matches, strengthMatch{
+					start:       start,
+					end:         end,
+					bits:        max(math.Log2(float64(known.rank)), 1) + caseBits + extraBits,
+					kind:        kind,
+					rank:        known.rank,
+					capitalized: caseBits > 0,
+					l33t:        l33t,
+					reversed:    reversed,
+					misspelled:  misspelled,
+				})
+			}
+
+			match(string(part), 0, false, false)
+			reversed := slices.Clone(part)
+			slices.Reverse(reversed)
+			match(string(reversed), 1, false, true)
+			// Symbols are turned back into letters before the word is
+			// looked up. A 1 reads as i or l; without one the second
+			// lookup would be the same as the first.
+			for _, one := range []rune{'i', 'l'} {
+				word, subs := unl33t(part, one)
+				if subs > 0 {
+					match(word, variationBits(len(part), subs), true, false)
+				}
+				if !slices.Contains(part, '1') {
+					break
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// minMisspelledRunes is the length a part needs before it's looked up with
+// a letter left out, shorter ones turn into words too easily.
+const minMisspelledRunes = 5
+
+// misspelledWord looks for a word that part is with one letter left out and
+// returns the best ranked one.
+func misspelledWord(part string) (string, dictionaryWord, bool) {
+	if len(part) < minMisspelledRunes || len(part) >= longestWord {
+		return "", dictionaryWord{}, false
+	}
+	for _, r := range part {
+		if r < 'a' || r > 'z' {
+			return "", dictionaryWord{}, false
+		}
+	}
+
+	var best string
+	var bestWord dictionaryWord
+	// Only letters a to z are left, so bytes are runes. Looking up
+	// string(candidate) doesn't copy it.
+	candidate := make([]byte, len(part)+1)
+	for at := 0; at <= len(part); at++ {
+		copy(candidate, part[:at])
+		copy(candidate[at+1:], part[at:])
+		for letter := byte('a'); letter <= 'z'; letter++ {
+			candidate[at] = letter
+			if known, ok := dictionary[string(candidate)]; ok && (best == "" || known.rank < bestWord.rank) {
+				best, bestWord = string(candidate), known
+			}
+		}
+	}
+	return best, bestWord, best != ""
+}
+
+func countUpper(word []rune) int {
+	upper := 0
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	return upper
+}
+
+// unl33t turns symbols in part back into letters, with '1' read as one. It
+// returns the word and how many symbols were turned, zero if part isn't
+// made of letters and symbols.
+func unl33t(part []rune, one rune) (string, int) {
+	subs, letters := 0, 0
+	word := make([]rune, len(part))
+	for i, r := range part {
+		if plain, ok := l33tTable[r]; ok {
+			word[i] = plain
+			subs++
+		} else if r == '1' {
+			word[i] = one
+			subs++
+		} else {
+			word[i] = r
+			if unicode.IsLetter(r) {
+				letters++
+			}
+		}
+	}
+	if letters == 0 {
+		return "", 0
+	}
+	return string(word), subs
+}
+
+// Rows and patterns of keys on a QWERTY keyboard
+
+type keyPosition struct {
+	row, col int
+	shifted  bool
+}
+
+var keyboardRows = [][2]string{
+	{"`1234567890-=", "~!@#$%^&*()_+"},
+	{"qwertyuiop[]\\", "QWERTYUIOP{}|"},
+	{"asdfghjkl;'", "ASDFGHJKL:\""},
+	{"zxcvbnm,./", "ZXCVBNM<>?"},
+}
+
+var keyboardKeys, averageKeyNeighbours = buildKeyboard()
+
+func buildKeyboard() (map[rune]keyPosition, float64) {
+	keys := make(map[rune]keyPosition)
+	for row, chars := range keyboardRows {
+		for col, r := range chars[0] {
+			keys[r] = keyPosition{row: row, col: col}
+		}
+		for col, r := range chars[1] {
+			keys[r] = keyPosition{row: row, col: col, shifted: true}
+		}
+	}
+
+	neighbours, count := 0, 0
+	for _, a := range keys {
+		if a.shifted {
+			continue
+		}
+		count++
+		for _, b := range keys {
+			if _, ok := keyDirection(a, b); ok && !b.shifted {
+				neighbours++
+			}
+		}
+	}
+	return keys, float64(neighbours) / float64(count)
+}
+
+// keyDirection returns which way b lies from a if they're next to each
+// other. Rows are shifted half a key to the right from the one below.
+func keyDirection(a, b keyPosition) ([2]int, bool) {
+	dRow, dCol := b.row-a.row, b.col-a.col
+	switch {
+	case dRow == 0 && (dCol == 1 || dCol == -1),
+		dRow == 1 && (dCol == -1 || dCol == 0),
+		dRow == -1 && (dCol == 0 || dCol == 1):
+		return [2]int{dRow, dCol}, true
+	}
+	return [2]int{}, false
+}
+
+func keyboardMatches(password []rune) []strengthMatch {
+	var matches []strengthMatch
+	for start := 0; start < len(password)-1; {
+		end, turns := start+1, 0
+		var lastDirection [2]int
+		for end < len(password) {
+			a, okA := keyboardKeys[password[end-1]]
+			b, okB := keyboardKeys[password[end]]
+			if !okA || !okB {
+				break
+			}
+			direction, ok := keyDirection(a, b)
+			if !ok {
+				break
+			}
+			if end > start+1 && direction != lastDirection {
+				turns++
+			}
+			lastDirection = direction
+			end++
+		}
+
+		if end-start >= 3 {
+			shifted := 0
+			for _, r := range password[start:end] {
+				if keyboardKeys[r].shifted {
+					shifted++
+				}
+			}
+			matches = append(matches, strengthMatch{
+				start: start,
+				end:   end,
+				bits: math.Log2(float64(len(keyboardKeys)/2)) + math.Log2(float64(end-start)) +
+					float64(turns)*math.Log2(averageKeyNeighbours) + variationBits(end-start, shifted),
+				kind: matchKeyboard,
+			})
+		}
+		start = max(end-1, start+1)
+	}
+	return matches
+}
+
+// Repeats like aaa or abcabc
+
+func (a *strengthAnalysis) repeatMatches(password []rune) []strengthMatch {
+	var matches []strengthMatch
+	for start := range password {
+		for size := 1; size <= (len(password)-start)/2; size++ {
+			base := password[start : start+size]
+			repeats := 1
+			for next := start + size; next+size <= len(password) && slices.Equal(password[next:next+size], base); next += size {
+				repeats++
+			}
+			// abab repeated is ab repeated, which is cheaper to estimate
+			if repeats < 2 || (size == 1 && repeats < 3) || isRepeat(base) {
+				continue
+			}
+			matches = append(matches, strengthMatch{
+				start: start,
+				end:   start + repeats*size,
+				bits:  a.baseBits(base) + math.Log2(float64(repeats)),
+				kind:  matchRepeat,
+			})
+		}
+	}
+	return matches
+}
+
+// isRepeat reports whether part is a shorter part repeated.
+func isRepeat(part []rune) bool {
+	for size := 1; size <= len(part)/2; size++ {
+		if len(part)%size != 0 {
+			continue
+		}
+		repeated := true
+		for i := size; i < len(part) && repeated; i += size {
+			repeated = slices.Equal(part[i:i+size], part[:size])
+		}
+		if repeated {
+			return true
+		}
+	}
+	return false
+}
+
+// baseBits estimates what a repeat repeats.
+func (a *strengthAnalysis) baseBits(base []rune) float64 {
+	if bits, ok := a.memo[string(base)]; ok {
+		return bits
+	}
+	bits, _ := a.bits(base)
+	a.memo[string(base)] = bits
+	return bits
+}
+
+// Sequences like abc or 6543
+
+func sequenceMatches(password []rune) []strengthMatch {
+	var matches []strengthMatch
+	for start := 0; start < len(password)-2; {
+		delta := password[start+1] - password[start]
+		end := start + 2
+		for end < len(password) && password[end]-password[end-1] == delta {
+			end++
+		}
+		class := sequenceClass(password[start:end])
+		if (delta != 1 && delta != -1) || end-start < 3 || class == 0 {
+			start++
+			continue
+		}
+
+		bits := math.Log2(float64(class))
+		if strings.ContainsRune("aAzZ019", password[start]) {
+			bits = 1
+		}
+		bits += math.Log2(float64(end - start))
+		if delta < 0 {
+			bits++
+		}
+		matches = append(matches, strengthMatch{start: start, end: end, bits: bits, kind: matchSequence})
+		start = end - 1
+	}
+	return matches
+}
+
+// sequenceClass returns how many characters the class of the sequence has,
+// zero if it mixes classes.
+func sequenceClass(sequence []rune) int {
+	in := func(from, to rune) bool {
+		for _, r := range sequence {
+			if r < from || r > to {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case in('a', 'z'), in('A', 'Z'):
+		return 26
+	case in('0', '9'):
+		return 10
+	}
+	return 0
+}
+
+// Dates and years
+
+const (
+	minYear = 1900
+	maxYear = 2049
+)
+
+var (
+	yearBits      = math.Log2(maxYear - minYear + 1)
+	dateBits      = math.Log2(365 * (maxYear - minYear + 1))
+	shortDateBits = math.Log2(365 * 100)
+
+	separatedDate = regexp.MustCompile(`\d{1,4}[ /\\._-]\d{1,2}[ /\\._-]\d{1,4}`)
+)
+
+func dateMatches(password []rune) []strengthMatch {
+	var matches []strengthMatch
+
+	// Runs of digits like 1987, 250387 or 19870325
+	for start := range password {
+		for end := start + 4; end <= len(password) && end-start <= 8; end++ {
+			digits := string(password[start:end])
+			if !allDigits(digits) {
+				break
+			}
+			if bits, ok := digitDateBits(digits); ok {
+				matches = append(matches, strengthMatch{start: start, end: end, bits: bits, kind: matchDate})
+			}
+		}
+	}
+
+	// Dates with separators like 25.03.1987 or 1987-03-25
+	s := string(password)
+	for _, loc := range separatedDate.FindAllStringIndex(s, -1) {
+		date := s[loc[0]:loc[1]]
+		first := strings.IndexAny(date, " /\\._-")
+		last := strings.LastIndexAny(date, " /\\._-")
+		if date[first] != date[last] {
+			continue
+		}
+		bits, ok := dayMonthYearBits(date[:first], date[first+1:last], date[last+1:])
+		if !ok {
+			continue
+		}
+		start := utf8.RuneCountInString(s[:loc[0]])
+		matches = append(matches, strengthMatch{
+			start: start,
+			end:   start + utf8.RuneCountInString(date),
+			bits:  bits + math.Log2(6),
+			kind:  matchDate,
+		})
+	}
+	return matches
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// digitDateBits returns the bits of a run of digits that reads as a year or a
+// date without separators.
+func digitDateBits(digits string) (float64, bool) {
+	switch len(digits) {
+	case 4:
+		year, _ := strconv.Atoi(digits)
+		return yearBits, year >= minYear && year <= maxYear
+	case 6:
+		return dayMonthYearBits(digits[:2], digits[2:4], digits[4:])
+	case 8:
+		if bits, ok := dayMonthYearBits(digits[:2], digits[2:4], digits[4:]); ok {
+			return bits, true
+		}
+		return dayMonthYearBits(digits[:4], digits[4:6], digits[6:])
+	}
+	return 0, false
+}
+
+// dayMonthYearBits returns the bits of a date given as three parts in any of
+// the usual orders.
+func dayMonthYearBits(first, second, third string) (float64, bool) {
+	parts := []string{first, second, third}
+	// Day, month and year of day/month/year, month/day/year and year/month/day
+	for _, order := range [][3]int{{0, 1, 2}, {1, 0, 2}, {2, 1, 0}} {
+		day, _ := strconv.Atoi(parts[order[0]])
+		month, _ := strconv.Atoi(parts[order[1]])
+		year, _ := strconv.Atoi(parts[order[2]])
+		if day < 1 || day > 31 || month < 1 || month > 12 {
+			continue
+		}
+		switch len(parts[order[2]]) {
+		case 2:
+			return shortDateBits, true
+		case 4:
+			if year >= minYear && year <= maxYear {
+				return dateBits, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Feedback
+
+// strengthFeedback explains what makes a password weak, starting with the
+// longest guessable part of it.
+func strengthFeedback(password []rune, used []strengthMatch) (string, []string) {
+	var suggestions []string
+	suggest := func(suggestion string) {
+		if !slices.Contains(suggestions, suggestion) {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	if len(password) < 12 {
+		suggest("Make it longer, a few uncommon words work well.")
+	}
+
+	warning := ""
+	longest := 0
+	for _, m := range used {
+		whole := m.start == 0 && m.end == len(password)
+		switch m.kind {
+		case matchPassword, matchWord:
+			suggest("Add another word or two, uncommon words are better.")
+			if m.capitalized {
+				suggest("Capital letters don't help very much.")
+			}
+			if m.l33t {
+				suggest("Symbols in place of letters, like @ for a, don't help much.")
+			}
+			if m.reversed {
+				suggest("Reversed words aren't much harder to guess.")
+			}
+			if m.misspelled {
+				suggest("Misspelled words aren't much harder to guess.")
+			}
+		case matchKeyboard:
+			suggest("Avoid rows and patterns of keys.")
+		case matchRepeat:
+			suggest("Avoid repeated words and characters.")
+		case matchSequence:
+			suggest("Avoid sequences.")
+		case matchDate:
+			suggest("Avoid dates and years that are associated with you.")
+		}
+
+		if m.end-m.start <= longest {
+			continue
+		}
+		longest = m.end - m.start
+		switch {
+		case m.kind == matchPassword && whole && m.rank <= 100:
+			warning = "This is one of the 100 most common passwords."
+		case m.kind == matchPassword && whole:
+			warning = "This is a very common password."
+		case m.kind == matchPassword:
+			warning = "This contains a very common password."
+		case m.kind == matchWord && whole:
+			warning = "A single word is easy to guess."
+		case m.kind == matchWord:
+			warning = "Common words and names are easy to guess."
+		case m.kind == matchKeyboard:
+			warning = "Rows and patterns of keys are easy to guess."
+		case m.kind == matchRepeat:
+			warning = `Repeats like "aaa" or "abcabc" are easy to guess.`
+		case m.kind == matchSequence:
+			warning = "Sequences like abc or 6543 are easy to guess."
+		case m.kind == matchDate:
+			warning = "Dates and years are easy to guess."
+		}
+	}
+	return warning, suggestions
+}
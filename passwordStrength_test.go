@@ -0,0 +1,71 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEstimateStrengthWeakPasswords(t *testing.T) {
+	tests := []struct {
+		password string
+		// maxScore is the best score the password may get, below the
+		// default minimum for master passwords
+		maxScore   int
+		warning    string
+		suggestion string
+	}{
+		{password: "password", maxScore: scoreVeryWeak, warning: "This is one of the 100 most common passwords."},
+		{password: "P@ssw0rd", maxScore: scoreVeryWeak, suggestion: "Symbols in place of letters, like @ for a, don't help much."},
+		{password: "Tr0ub4dor&3", maxScore: scoreWeak, suggestion: "Misspelled words aren't much harder to guess."},
+		{password: "troubador", maxScore: scoreVeryWeak, suggestion: "Misspelled words aren't much harder to guess."},
+		{password: "d4rkn3ss", maxScore: scoreVeryWeak, suggestion: "Symbols in place of letters, like @ for a, don't help much."},
+		{password: "19871231", maxScore: scoreVeryWeak, warning: "Dates and years are easy to guess."},
+		{password: "31121987", maxScore: scoreVeryWeak, warning: "Dates and years are easy to guess."},
+		{password: "1987-12-31", maxScore: scoreVeryWeak, warning: "Dates and years are easy to guess."},
+		{password: "12345678", maxScore: scoreVeryWeak},
+		{password: "abcdefgh", maxScore: scoreVeryWeak, warning: "Sequences like abc or 6543 are easy to guess."},
+		{password: "abcabcabc", maxScore: scoreVeryWeak, warning: `Repeats like "aaa" or "abcabc" are easy to guess.`},
+		{password: "qwertyuiop", maxScore: scoreVeryWeak, warning: "Rows and patterns of keys are easy to guess."},
+		{password: "1qaz2wsx", maxScore: scoreVeryWeak},
+		{password: "iloveyou1", maxScore: scoreVeryWeak},
+		{password: "Summer2019!", maxScore: scoreVeryWeak, suggestion: "Capital letters don't help very much."},
+		{password: "jennifer1990", maxScore: scoreVeryWeak, suggestion: "Avoid dates and years that are associated with you."},
+		{password: "drowssap", maxScore: scoreVeryWeak, suggestion: "Reversed words aren't much harder to guess."},
+		// Past maxAnalyzedRunes
+		{password: strings.Repeat("xy", 60), maxScore: scoreVeryWeak},
+		{password: strings.Repeat("é", 120), maxScore: scoreVeryWeak},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			strength := EstimateStrength(tt.password)
+			if strength.Score > tt.maxScore {
+				t.Errorf("scored %s (%.1f bits), want at most %s", scoreNames[strength.Score], strength.Bits, scoreNames[tt.maxScore])
+			}
+			if tt.warning != "" && strength.Warning != tt.warning {
+				t.Errorf("warning %q, want %q", strength.Warning, tt.warning)
+			}
+			if tt.suggestion != "" && !slices.Contains(strength.Suggestions, tt.suggestion) {
+				t.Errorf("suggestions %q, want %q among them", strength.Suggestions, tt.suggestion)
+			}
+		})
+	}
+}
+
+func TestEstimateStrengthStrongPasswords(t *testing.T) {
+	for _, password := range []string{
+		"q8#Vr2!mZp0&xT",
+		"gravel wombat tundra lantern",
+		"Tr0ub4dor&3 gravel wombat",
+	} {
+		t.Run(password, func(t *testing.T) {
+			strength := EstimateStrength(password)
+			if strength.Score < scoreStrong {
+				t.Errorf("scored %s (%.1f bits), want at least %s", scoreNames[strength.Score], strength.Bits, scoreNames[scoreStrong])
+			}
+			if strength.Warning != "" || len(strength.Suggestions) > 0 {
+				t.Errorf("got feedback %q %q for a strong password", strength.Warning, strength.Suggestions)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Vault files are only for their owner: they're written 0600 and the vaults
+// directory is created 0700. Vaults from older versions, or copied around,
+// may still be open to other users, so the stores on disk check the
+// permissions whenever the vaults are listed and the vaults view offers to
+// fix them.
+const (
+	vaultFileMode = 0600
+	vaultDirMode  = 0700
+)
+
+// PermissionProblem is a file or directory of the store that other users can
+// get at.
+type PermissionProblem struct {
+	Path string
+	Mode fs.FileMode
+	// Owner is the uid of the owner if it isn't the current user, -1
+	// otherwise. Only the owner or root can change that.
+	Owner int
+}
+
+func (p PermissionProblem) String() string {
+	if p.Owner >= 0 {
+		return fmt.Sprintf("%s is owned by user %d", p.Path, p.Owner)
+	}
+	return fmt.Sprintf("%s is %04o, open to other users", p.Path, p.Mode.Perm())
+}
+
+// Fixable reports whether FixPermissions can fix the problem.
+func (p PermissionProblem) Fixable() bool {
+	return p.Owner < 0
+}
+
+// permissionChecker is implemented by the stores that keep vaults on disk.
+type permissionChecker interface {
+	// CheckPermissions returns the files and directories of the store other
+	// users can get at.
+	CheckPermissions() ([]PermissionProblem, error)
+	// FixPermissions takes away what other users can do with them.
+	FixPermissions() error
+}
+
+// checkPermissions checks the files at paths, a missing one is fine.
+func checkPermissions(paths ...string) ([]PermissionProblem, error) {
+	if !unixPermissions {
+		return nil, nil
+	}
+	var problems []PermissionProblem
+	var errs []error
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		owner, ok := fileOwner(info)
+		if ok && owner != os.Getuid() {
+			problems = append(problems, PermissionProblem{Path: path, Mode: info.Mode(), Owner: owner})
+		} else if info.Mode().Perm()&0077 != 0 {
+			problems = append(problems, PermissionProblem{Path: path, Mode: info.Mode(), Owner: -1})
+		}
+	}
+	return problems, errors.Join(errs...)
+}
+
+// fixPermissions takes away the group and other bits of the problems it can
+// fix.
+func fixPermissions(problems []PermissionProblem) error {
+	var errs []error
+	for _, problem := range problems {
+		if !problem.Fixable() {
+			continue
+		}
+		if err := os.Chmod(problem.Path, problem.Mode.Perm()&^0077); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckStorePermissions returns the permission problems of the store, none
+// for a store that isn't on disk.
+func CheckStorePermissions() ([]PermissionProblem, error) {
+	checker, ok := store.(permissionChecker)
+	if !ok {
+		return nil, nil
+	}
+	return checker.CheckPermissions()
+}
+
+// FixStorePermissions fixes what CheckStorePermissions found, apart from
+// files owned by someone else.
+func FixStorePermissions() error {
+	checker, ok := store.(permissionChecker)
+	if !ok {
+		return nil
+	}
+	return checker.FixPermissions()
+}
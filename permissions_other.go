@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// Files have no unix mode or uid owner here, access is up to the ACLs of the
+// system and isn't checked.
+const unixPermissions = false
+
+func fileOwner(info fs.FileInfo) (int, bool) {
+	return 0, false
+}
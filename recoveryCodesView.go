@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	lg "github.com/charmbracelet/lipgloss"
+)
+
+type RecoveryCodesModel struct {
+	keys      keyMap
+	help      help.Model
+	w, h      int
+	mainModel *mainModel
+
+	codes []string
+	// next is the view to go to once the codes are saved.
+	next int
+
+	decryptedVaultKey *LockedBuffer
+	vault             Vault
+}
+
+func InitialRecoveryCodesModel(mainmdl *mainModel) RecoveryCodesModel {
+	m := RecoveryCodesModel{
+		keys:      keysRecoveryCodes,
+		help:      help.New(),
+		mainModel: mainmdl,
+		next:      homeView,
+	}
+	return m
+}
+
+func (m RecoveryCodesModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m RecoveryCodesModel) View() string {
+	s := ""
+	s += titleStyle.Render(fmt.Sprintf("Recovery codes of %s", highlightStyle.Render(m.vault.Name)))
+	s += "\n"
+
+	s += "Each code opens the vault once if the master password is lost.\n"
+	s += errorStyle.Render("They won't be shown again, write them down now!")
+	s += "\n"
+	s += formBorderStyle.Render(highlightStyle.Render(strings.Join(m.codes, "\n")))
+	s += "\n"
+	s += fmt.Sprintf("Press %s once you've saved them.\n", highlightStyle.Render("y"))
+
+	helpView := m.help.View(m.keys)
+	s += helpStyle.Render(helpView)
+	s = lg.Place(m.w, m.h, lg.Center, lg.Center, s)
+	return s
+}
+
+// Sending freshly generated recovery codes to the recovery codes view.
+type SendRecoveryCodesMsg struct {
+	Codes []string
+	Next  int
+}
+
+func SendRecoveryCodesCmd(codes []string, next int) tea.Cmd {
+	return func() tea.Msg {
+		return SendRecoveryCodesMsg{Codes: codes, Next: next}
+	}
+}
+
+func (m RecoveryCodesModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if model, cmd, ok := m.mainModel.autoLock(m, msg, m.vault, true); ok {
+		return model, cmd
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		case key.Matches(msg, m.keys.Confirm):
+			// Forget the codes before leaving
+			m.codes = nil
+			m.mainModel.recoveryCodesView = InitialRecoveryCodesModel(m.mainModel)
+
+			m.mainModel.viewState = m.next
+			switch m.next {
+			case keySlotsView:
+				return m.mainModel.keySlotsView, tea.Batch(tea.WindowSize(), SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey))
+			case vaultView:
+				return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey))
+			}
+			// The vault was only open to create it
+			DestroyVaultBuffers(m.vault.vaultKey)
+			return m.mainModel.homeView, tea.WindowSize()
+		}
+	case SendRecoveryCodesMsg:
+		m.codes = msg.Codes
+		m.next = msg.Next
+		return m, nil
+	case SendDecryptedVaultKeyMsg:
+		m.decryptedVaultKey = msg.VaultKey
+		return m, nil
+	case SendVaultMsg:
+		m.vault = msg.VaultSended
+		return m, nil
+	case tea.WindowSizeMsg:
+		m.w = msg.Width
+		m.h = msg.Height
+		m.help.Width = msg.Width
+	}
+	return m, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Secret texts are masked in the vault view. Only the highlighted secret can
+// be revealed, and it is masked again after revealTimeout or when the cursor
+// moves on. Vaults with ConfirmSensitive ask for the master password before a
+// secret marked sensitive is revealed, copied or edited.
+const revealTimeout = 15 * time.Second
+
+// secretMask stands in for every secret text, whatever its length.
+const secretMask = "••••••••"
+
+// What the vault view does once the master password was confirmed.
+const (
+	confirmNone = iota
+	confirmReveal
+	confirmCopy
+	confirmEdit
+)
+
+// SetConfirmSensitive changes whether an open vault asks for the master
+// password before revealing sensitive secrets, and saves it.
+func SetConfirmSensitive(vault Vault, confirm bool) (Vault, error) {
+	changed := vault
+	changed.ConfirmSensitive = confirm
+	saved, err := SaveVault(changed)
+	if err != nil {
+		return vault, err
+	}
+	return saved, nil
+}
+
+type RevealTickMsg struct{}
+
+func revealTick(after time.Duration) tea.Cmd {
+	return tea.Tick(after, func(time.Time) tea.Msg {
+		return RevealTickMsg{}
+	})
+}
+
+func newConfirmPasswordInput() textinput.Model {
+	t := textinput.New()
+	t.Cursor.Style = cursorStyle
+	t.Placeholder = "Master password"
+	t.Width = 20
+	t.EchoMode = textinput.EchoPassword
+	t.EchoCharacter = '•'
+	return t
+}
+
+// secretTextView is what the vault view shows as the text of secret i.
+func (m VaultModel) secretTextView(i int) string {
+	text := secretMask
+	if i == m.revealed {
+		text = m.decryptedVaultSecrets[i].SecretText.String()
+	}
+	if m.vault.Secrets[i].Sensitive {
+		text += "\nsensitive"
+	}
+	return text
+}
+
+// needsConfirm reports whether the highlighted secret may only be revealed,
+// copied or edited after the master password was given again.
+func (m VaultModel) needsConfirm() bool {
+	return m.vault.ConfirmSensitive && m.vault.Secrets[m.cursor].Sensitive
+}
+
+func (m VaultModel) toggleReveal() (tea.Model, tea.Cmd) {
+	if m.revealed == m.cursor {
+		m.revealed = -1
+		return m, nil
+	}
+	if m.needsConfirm() {
+		return m.startConfirm(confirmReveal)
+	}
+	return m.reveal()
+}
+
+func (m VaultModel) reveal() (tea.Model, tea.Cmd) {
+	m.revealed = m.cursor
+	m.revealUntil = time.Now().Add(revealTimeout)
+	return m, revealTick(revealTimeout)
+}
+
+// masked re-masks the revealed secret once its time is up.
+func (m VaultModel) masked() (tea.Model, tea.Cmd) {
+	if m.revealed < 0 {
+		return m, nil
+	}
+	if left := time.Until(m.revealUntil); left > 0 {
+		return m, revealTick(left)
+	}
+	m.revealed = -1
+	return m, nil
+}
+
+func (m VaultModel) startConfirm(action int) (tea.Model, tea.Cmd) {
+	m.confirming = action
+	m.errorMsg = ""
+	m.confirmPassword = newConfirmPasswordInput()
+	m.confirmKeyfile = newKeyfileInput()
+	return m, m.confirmPassword.Focus()
+}
+
+// updateConfirm handles the keys while the master password is asked for.
+func (m VaultModel) updateConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Quit):
+		return m, tea.Quit
+	case key.Matches(msg, m.keys.Back):
+		m.confirming = confirmNone
+		m.errorMsg = ""
+		return m, nil
+	case key.Matches(msg, m.keys.Enter):
+		return m.handleConfirm()
+	case key.Matches(msg, m.keys.Up) || key.Matches(msg, m.keys.Down):
+		if !m.vault.RequiresKeyfile() {
+			return m, nil
+		}
+		// Switch between the password and the keyfile field
+		if m.confirmPassword.Focused() {
+			m.confirmPassword.Blur()
+			return m, m.confirmKeyfile.Focus()
+		}
+		m.confirmKeyfile.Blur()
+		return m, m.confirmPassword.Focus()
+	}
+	return m, m.updateConfirmInputs(msg)
+}
+
+func (m *VaultModel) updateConfirmInputs(msg tea.Msg) tea.Cmd {
+	if m.confirming == confirmNone {
+		return nil
+	}
+	var passwordCmd, keyfileCmd tea.Cmd
+	m.confirmPassword, passwordCmd = m.confirmPassword.Update(msg)
+	m.confirmKeyfile, keyfileCmd = m.confirmKeyfile.Update(msg)
+	return tea.Batch(passwordCmd, keyfileCmd)
+}
+
+// handleConfirm checks the master password against the password slots,
+// throttled like an unlock, and then does what it was asked for. A recovery
+// code isn't taken, it would unlock but isn't the master password.
+func (m VaultModel) handleConfirm() (tea.Model, tea.Cmd) {
+	creds, err := NewCredentials(m.confirmPassword.Value(), strings.TrimSpace(m.confirmKeyfile.Value()))
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error reading keyfile: %v", err)
+		return m, nil
+	}
+	err = ThrottledConfirm(m.vault, creds)
+	if errors.Is(err, ErrWrongPassword) {
+		m.errorMsg = "Wrong master password!"
+		if m.vault.RequiresKeyfile() && creds.Keyfile == nil {
+			m.errorMsg = "Wrong master password or missing keyfile!"
+		}
+		m.confirmPassword.Reset()
+		return m, nil
+	} else if err != nil {
+		m.errorMsg = err.Error()
+		return m, nil
+	}
+
+	action := m.confirming
+	m.confirming = confirmNone
+	m.errorMsg = ""
+	switch action {
+	case confirmCopy:
+		return m.handleCopy(false)
+	case confirmEdit:
+		return m.handleEdit()
+	}
+	return m.reveal()
+}
+
+// confirmView asks for the master password under the secrets.
+func (m VaultModel) confirmView() string {
+	action := map[int]string{
+		confirmReveal: "reveal",
+		confirmCopy:   "copy",
+		confirmEdit:   "edit",
+	}[m.confirming]
+	s := fmt.Sprintf("%s is sensitive, enter the master password to %s it.\n", highlightStyle.Render(m.decryptedVaultSecrets[m.cursor].SecretName), action)
+	fields := focusedStyle.Render(m.confirmPassword.View())
+	if m.vault.RequiresKeyfile() {
+		fields += "\n" + m.confirmKeyfile.View()
+	}
+	s += formBorderStyle.Render(fields)
+	s += "\n"
+	return s
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	lg "github.com/charmbracelet/lipgloss"
+)
+
+type RotateKeyModel struct {
+	keys      keyMap
+	help      help.Model
+	w, h      int
+	mainModel *mainModel
+
+	errorMsg     string
+	textInput    textinput.Model
+	keyfileInput textinput.Model
+	progress     progress.Model
+	rotating     bool
+	done         int
+	total        int
+
+	// Once the key is rotated, the slots it removed are listed before going
+	// back to the vault.
+	rotated    Vault
+	rotatedKey *LockedBuffer
+	dropped    []KeySlot
+
+	decryptedVaultKey *LockedBuffer
+	vault             Vault
+}
+
+func InitialRotateKeyModel(mainmdl *mainModel) RotateKeyModel {
+	m := RotateKeyModel{
+		keys:      keysRotateKey,
+		help:      help.New(),
+		mainModel: mainmdl,
+		progress:  progress.New(progress.WithSolidFill(primaryHighlight), progress.WithWidth(40)),
+	}
+
+	ti := textinput.New()
+	ti.Placeholder = "Master password"
+	ti.Focus()
+	ti.Width = 20
+	ti.EchoMode = textinput.EchoPassword
+	ti.EchoCharacter = '•'
+
+	m.textInput = ti
+	m.keyfileInput = newKeyfileInput()
+	return m
+}
+
+func (m RotateKeyModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m RotateKeyModel) View() string {
+	s := ""
+	s += titleStyle.Render(fmt.Sprintf("Rotate key of %s", highlightStyle.Render(m.vault.Name)))
+	s += "\n"
+
+	if m.rotatedKey != nil {
+		s += "Vault key rotated. These key slots no longer open the vault and were removed:\n"
+		descriptions := []string{}
+		for _, slot := range m.dropped {
+			descriptions = append(descriptions, slot.describe())
+		}
+		s += formBorderStyle.Render(strings.Join(descriptions, "\n"))
+		s += "\n"
+		if hasRecoverySlot(m.dropped) {
+			s += fmt.Sprintf("Press %s to generate new recovery codes, ", highlightStyle.Render("g"))
+			s += fmt.Sprintf("%s to go back to the vault.\n", highlightStyle.Render("enter"))
+		} else {
+			s += fmt.Sprintf("Press %s to go back to the vault.\n", highlightStyle.Render("enter"))
+		}
+	} else if m.rotating {
+		percent := 1.0
+		if m.total > 0 {
+			percent = float64(m.done) / float64(m.total)
+		}
+		s += fmt.Sprintf("Re-encrypting secrets %d/%d\n", m.done, m.total)
+		s += m.progress.ViewAs(percent)
+		s += "\n"
+	} else {
+		s += "Every secret will be encrypted again under a new vault key.\n"
+		s += "Key slots your password and keyfile don't open are removed.\n"
+		s += focusedStyle.Render(m.textInput.View())
+		s += "\n"
+		s += m.keyfileInput.View()
+		s += "\n"
+		s += fmt.Sprintf("Press %s to rotate the key.\n", highlightStyle.Render("enter"))
+	}
+
+	s += errorStyle.Render(m.errorMsg)
+	s += "\n"
+
+	helpView := m.help.View(m.keys)
+	s += helpStyle.Render(helpView)
+	s = lg.Place(m.w, m.h, lg.Center, lg.Center, s)
+	return s
+}
+
+// Progress and result of a running key rotation.
+type RotateProgressMsg struct {
+	Done, Total int
+}
+
+type RotateDoneMsg struct {
+	Vault    Vault
+	VaultKey *LockedBuffer
+	Dropped  []KeySlot
+	Err      error
+}
+
+func RotateVaultKeyCmd(vault Vault, creds Credentials) tea.Cmd {
+	return func() tea.Msg {
+		// Quitting stops the rotation before the locked buffers are wiped
+		if !startBackground() {
+			return nil
+		}
+		defer backgroundWork.Done()
+		rotated, vaultKey, dropped, err := RotateVaultKey(vault, creds, func(done, total int) error {
+			if err := backgroundCtx.Err(); err != nil {
+				return err
+			}
+			Program.Send(RotateProgressMsg{Done: done, Total: total})
+			return nil
+		})
+		return RotateDoneMsg{Vault: rotated, VaultKey: vaultKey, Dropped: dropped, Err: err}
+	}
+}
+
+func (m RotateKeyModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if model, cmd, ok := m.mainModel.autoLock(m, msg, m.vault, m.rotating); ok {
+		return model, cmd
+	}
+
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		case m.rotating:
+			// Ignore everything else until the rotation is finished
+			return m, nil
+		case m.rotatedKey != nil:
+			return m.updateRotated(msg)
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		case key.Matches(msg, m.keys.Back):
+			m.mainModel.rotateKeyView = InitialRotateKeyModel(m.mainModel)
+			m.mainModel.viewState = vaultView
+			return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(m.vault), SendDecryptedVaultKeyCmd(m.decryptedVaultKey))
+		case key.Matches(msg, m.keys.Enter):
+			if len(m.textInput.Value()) == 0 {
+				m.errorMsg = "Enter the master password to rotate the key!"
+				return m, nil
+			}
+			creds, err := NewCredentials(m.textInput.Value(), strings.TrimSpace(m.keyfileInput.Value()))
+			if err != nil {
+				m.errorMsg = fmt.Sprintf("Error reading keyfile: %v", err)
+				return m, nil
+			}
+			m.errorMsg = ""
+			m.rotating = true
+			m.done, m.total = 0, len(m.vault.Secrets)
+			return m, RotateVaultKeyCmd(m.vault, creds)
+		case key.Matches(msg, m.keys.Up) || key.Matches(msg, m.keys.Down):
+			// Switch between the password and the keyfile field
+			if m.textInput.Focused() {
+				m.textInput.Blur()
+				return m, m.keyfileInput.Focus()
+			}
+			m.keyfileInput.Blur()
+			return m, m.textInput.Focus()
+		}
+	case RotateProgressMsg:
+		m.done, m.total = msg.Done, msg.Total
+		return m, nil
+	case RotateDoneMsg:
+		m.rotating = false
+		if model, cmd, ok := m.mainModel.vaultConflict(msg.Err, m.vault, m.decryptedVaultKey); ok {
+			m.mainModel.rotateKeyView = InitialRotateKeyModel(m.mainModel)
+			return model, cmd
+		} else if errors.Is(msg.Err, ErrWrongPassword) {
+			m.errorMsg = "Wrong master password!"
+			return m, nil
+		} else if errors.Is(msg.Err, ErrUnlockThrottled) {
+			m.errorMsg = msg.Err.Error()
+			return m, nil
+		} else if msg.Err != nil {
+			m.errorMsg = fmt.Sprintf("Error rotating key, vault left unchanged: %v", msg.Err)
+			return m, nil
+		}
+
+		// The old key is of no use anymore, nor what was decrypted with it
+		DestroyVaultBuffers(m.decryptedVaultKey)
+		m.decryptedVaultKey = nil
+
+		if len(msg.Dropped) > 0 {
+			// Show which slots are gone before going back
+			m.vault = msg.Vault
+			m.rotated, m.rotatedKey, m.dropped = msg.Vault, msg.VaultKey, msg.Dropped
+			m.keys = RotatedKeyMap(hasRecoverySlot(msg.Dropped))
+			return m, nil
+		}
+		return m.backToVault(msg.Vault, msg.VaultKey, "Vault key rotated successfully")
+	case SendDecryptedVaultKeyMsg:
+		m.decryptedVaultKey = msg.VaultKey
+		return m, nil
+	case SendVaultMsg:
+		m.vault = msg.VaultSended
+		return m, nil
+	case tea.WindowSizeMsg:
+		m.w = msg.Width
+		m.h = msg.Height
+		m.help.Width = msg.Width
+	}
+
+	var keyfileCmd tea.Cmd
+	m.textInput, cmd = m.textInput.Update(msg)
+	m.keyfileInput, keyfileCmd = m.keyfileInput.Update(msg)
+	return m, tea.Batch(cmd, keyfileCmd)
+}
+
+// updateRotated handles the keys while the removed slots are shown.
+func (m RotateKeyModel) updateRotated(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Help):
+		m.help.ShowAll = !m.help.ShowAll
+	case key.Matches(msg, m.keys.Enter) || key.Matches(msg, m.keys.Back):
+		confirmation := fmt.Sprintf("Vault key rotated successfully, %d key slot(s) removed", len(m.dropped))
+		return m.backToVault(m.rotated, m.rotatedKey, confirmation)
+	case key.Matches(msg, m.keys.RecoveryCodes) && hasRecoverySlot(m.dropped):
+		vault, codes, err := GenerateRecoveryCodes(m.rotated, m.rotatedKey)
+		if model, cmd, ok := m.mainModel.vaultConflict(err, m.rotated, m.rotatedKey); ok {
+			m.mainModel.rotateKeyView = InitialRotateKeyModel(m.mainModel)
+			return model, cmd
+		} else if err != nil {
+			m.errorMsg = fmt.Sprintf("Error generating recovery codes: %v", err)
+			return m, nil
+		}
+
+		m.mainModel.rotateKeyView = InitialRotateKeyModel(m.mainModel)
+		m.mainModel.viewState = recoveryCodesView
+		return m.mainModel.recoveryCodesView, tea.Batch(tea.WindowSize(), SendVaultCmd(vault), SendDecryptedVaultKeyCmd(m.rotatedKey), SendRecoveryCodesCmd(codes, vaultView))
+	}
+	return m, nil
+}
+
+// backToVault resets the view and opens the rotated vault.
+func (m RotateKeyModel) backToVault(vault Vault, vaultKey *LockedBuffer, confirmation string) (tea.Model, tea.Cmd) {
+	m.mainModel.rotateKeyView = InitialRotateKeyModel(m.mainModel)
+	m.mainModel.viewState = vaultView
+	return m.mainModel.vaultView, tea.Batch(tea.WindowSize(), SendVaultCmd(vault), SendDecryptedVaultKeyCmd(vaultKey), SendConfirmationCmd(confirmation))
+}
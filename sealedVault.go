@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// A sealed vault keeps only its key slots in the clear. Its name, description
+// and secrets are stored as one encrypted payload, so the file doesn't tell
+// what the vault is called or how many secrets it holds. With padding the
+// payload is also grown to a fixed bucket size to hide its exact length.
+
+// Padded payloads are grown to the next power of two, but never less than
+// this.
+const minSealBucket = 4096
+
+// Sealed vaults are stored as sealed-<the first characters of their ID>.
+const sealedNameIDLength = 12
+
+// sealedContents is what a sealed vault encrypts.
+type sealedContents struct {
+	Name        string   `json:"Name"`
+	Description string   `json:"Description"`
+	Secrets     []Secret `json:"Secrets"`
+}
+
+// FileName is the name of the vault file without its extension. Sealed
+// vaults are stored under a name made from their ID so the file name doesn't
+// give their real name away.
+func (v Vault) FileName() string {
+	switch {
+	case v.fileName != "":
+		return v.fileName
+	case v.Sealed && len(v.ID) >= sealedNameIDLength:
+		return "sealed-" + v.ID[:sealedNameIDLength]
+	default:
+		return v.Name
+	}
+}
+
+// IsLocked reports whether the vault is sealed and hasn't been opened yet, so
+// its name and secrets aren't known.
+func (v Vault) IsLocked() bool {
+	return v.Sealed && v.vaultKey == nil
+}
+
+// DisplayName is the name of the vault as it is shown before it is unlocked.
+func (v Vault) DisplayName() string {
+	if v.IsLocked() {
+		return "Sealed vault"
+	}
+	return v.Name
+}
+
+func sealAAD(vault Vault) []byte {
+	return []byte(fmt.Sprintf("ciphery/%s/sealed", vault.ID))
+}
+
+// sealBucket returns the size a payload of n bytes is padded to.
+func sealBucket(n int) int {
+	size := minSealBucket
+	for size < n {
+		size *= 2
+	}
+	return size
+}
+
+// sealContents encrypts the name, description and secrets of an open vault.
+// The returned copy is what goes into the file.
+func sealContents(vault Vault) (Vault, error) {
+	contents, err := json.Marshal(sealedContents{
+		Name:        vault.Name,
+		Description: vault.Description,
+		Secrets:     vault.Secrets,
+	})
+	if err != nil {
+		return vault, err
+	}
+	if vault.SealPadding {
+		// Trailing whitespace is valid json, so the padding needs no
+		// length prefix to be stripped again.
+		padded := make([]byte, sealBucket(len(contents)))
+		copy(padded, contents)
+		for i := len(contents); i < len(padded); i++ {
+			padded[i] = ' '
+		}
+		contents = padded
+	}
+
+	vault.SealedCipher = vault.CipherName()
+	ciphertext, nonce, err := encryptWith(vault.SealedCipher, contents, vault.vaultKey.Bytes(), sealAAD(vault))
+	if err != nil {
+		return vault, err
+	}
+	vault.EncodedSealedPayload = base64.StdEncoding.EncodeToString(ciphertext)
+	vault.EncodedSealedNonce = base64.StdEncoding.EncodeToString(nonce)
+
+	vault.Name = ""
+	vault.Description = ""
+	vault.Secrets = nil
+	return vault, nil
+}
+
+// OpenVault keeps the vault key of an unlocked vault with it, so the vault
+// can be sealed and authenticated again when it is saved. The contents of a
+// sealed vault are decrypted. Vaults that are already open are returned as
+// they are.
+func OpenVault(vault Vault, vaultKey *LockedBuffer) (Vault, error) {
+	if vault.vaultKey != nil {
+		return vault, nil
+	}
+	if !vault.Sealed {
+		vault.vaultKey = vaultKey
+		return vault, nil
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(vault.EncodedSealedPayload)
+	if err != nil {
+		return vault, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(vault.EncodedSealedNonce)
+	if err != nil {
+		return vault, err
+	}
+	plaintext, err := decryptWith(vault.SealedCipher, ciphertext, vaultKey.Bytes(), nonce, sealAAD(vault))
+	if err != nil {
+		return vault, fmt.Errorf("opening sealed contents: %w", err)
+	}
+
+	var contents sealedContents
+	if err := json.Unmarshal(plaintext, &contents); err != nil {
+		return vault, fmt.Errorf("reading sealed contents: %w", err)
+	}
+
+	unsealed := vault
+	unsealed.Name = contents.Name
+	unsealed.Description = contents.Description
+	unsealed.Secrets = contents.Secrets
+	if unsealed.Secrets == nil {
+		unsealed.Secrets = []Secret{}
+	}
+	unsealed.vaultKey = vaultKey
+	return unsealed, nil
+}
+
+// SealVault turns an unlocked vault into a sealed one. It is saved under its
+// new file name and the old file is removed, along with every copy the store
+// kept under the old name.
+func SealVault(vault Vault, vaultKey *LockedBuffer, padding bool) (Vault, error) {
+	if vault.Sealed {
+		return vault, errors.New("vault is already sealed")
+	}
+	if len(vault.ID) < sealedNameIDLength {
+		return vault, errors.New("vault has no ID to name the sealed file after")
+	}
+
+	sealed := vault
+	sealed.Sealed = true
+	sealed.SealPadding = padding
+	sealed.vaultKey = vaultKey
+	sealed.fileName = ""
+	sealed, err := SaveVault(sealed)
+	if err != nil {
+		return vault, err
+	}
+
+	// Whatever the store kept under the old name would still give it away,
+	// even when the name stays the same and only the copies are left
+	if err := store.DropCopies(vault.FileName()); err != nil {
+		return sealed, fmt.Errorf("vault sealed as %s but removing the unsealed copies failed: %w", sealed.FileName(), err)
+	}
+	if vault.FileName() == sealed.FileName() {
+		return sealed, nil
+	}
+	if err := store.Delete(vault.FileName()); err != nil {
+		return sealed, fmt.Errorf("vault sealed as %s but removing the old one failed: %w", sealed.FileName(), err)
+	}
+	return sealed, nil
+}
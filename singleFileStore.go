@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// SingleFileStore keeps every vault in one file, which is easier to sync or
+// hand around than a directory. The file is a json object of the stored vault
+// files. Every save replaces the whole file through writeFileAtomic while
+// holding a lock on <file>.lock, so saves to different vaults wait for each
+// other too. Vaults are a few kilobytes each, so writing all of them on every
+// save costs little, and a crash leaves either the old file or the new one.
+type SingleFileStore struct {
+	path string
+}
+
+// singleFile is the contents of a SingleFileStore.
+type singleFile struct {
+	Vaults map[string]json.RawMessage `json:"Vaults"`
+	// The copy of each vault the last save replaced
+	Previous map[string]json.RawMessage `json:"Previous,omitempty"`
+	// The copies made before migrations, by name and format version
+	Backups map[string]json.RawMessage `json:"Backups,omitempty"`
+}
+
+func NewSingleFileStore(path string) SingleFileStore {
+	return SingleFileStore{path: path}
+}
+
+// read returns the contents of the store, empty ones if the file doesn't
+// exist yet.
+func (s SingleFileStore) read() (singleFile, error) {
+	contents := singleFile{
+		Vaults:   map[string]json.RawMessage{},
+		Previous: map[string]json.RawMessage{},
+		Backups:  map[string]json.RawMessage{},
+	}
+	fileByte, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return contents, nil
+	} else if err != nil {
+		return contents, err
+	}
+	if err := json.Unmarshal(fileByte, &contents); err != nil {
+		return contents, fmt.Errorf("reading %s: %w", s.path, err)
+	}
+	return contents, nil
+}
+
+// update changes the contents of the store with change and writes them,
+// unless change fails.
+func (s SingleFileStore) update(change func(contents *singleFile) error) error {
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	contents, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := change(&contents); err != nil {
+		return err
+	}
+	fileByte, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, fileByte, vaultFileMode)
+}
+
+func (s SingleFileStore) List() ([]string, error) {
+	contents, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(contents.Vaults)), nil
+}
+
+func (s SingleFileStore) Load(name string) ([]byte, error) {
+	contents, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	vaultByte, ok := contents.Vaults[name]
+	if !ok {
+		return nil, notStored(name)
+	}
+	return vaultByte, nil
+}
+
+func (s SingleFileStore) Save(name string, data []byte, revision uint64) error {
+	return s.update(func(contents *singleFile) error {
+		current, ok := contents.Vaults[name]
+		if !ok {
+			contents.Vaults[name] = data
+			return nil
+		}
+		if err := checkStoredRevision(current, nil, revision); err != nil {
+			return err
+		}
+		if sameKeySlots(current, data) {
+			contents.Previous[name] = current
+		} else {
+			delete(contents.Previous, name)
+		}
+		contents.Vaults[name] = data
+		return nil
+	})
+}
+
+// Delete removes the vault, its previous copy and the copies made before
+// migrations.
+func (s SingleFileStore) Delete(name string) error {
+	return s.update(func(contents *singleFile) error {
+		if _, ok := contents.Vaults[name]; !ok {
+			return notStored(name)
+		}
+		delete(contents.Vaults, name)
+		contents.dropCopies(name)
+		return nil
+	})
+}
+
+func (s SingleFileStore) Backup(name string, version int) error {
+	return s.update(func(contents *singleFile) error {
+		vaultByte, ok := contents.Vaults[name]
+		if !ok {
+			return notStored(name)
+		}
+		key := fmt.Sprintf("%s.v%d", name, version)
+		if _, ok := contents.Backups[key]; !ok {
+			contents.Backups[key] = vaultByte
+		}
+		return nil
+	})
+}
+
+func (s SingleFileStore) DropCopies(name string) error {
+	return s.update(func(contents *singleFile) error {
+		contents.dropCopies(name)
+		return nil
+	})
+}
+
+// dropCopies removes the previous copy and the migration backups of name.
+func (contents *singleFile) dropCopies(name string) {
+	delete(contents.Previous, name)
+	for key := range contents.Backups {
+		if isBackupOf(key, name) {
+			delete(contents.Backups, key)
+		}
+	}
+}
+
+// CheckPermissions checks the file, its lock file and the directory they're
+// in.
+func (s SingleFileStore) CheckPermissions() ([]PermissionProblem, error) {
+	return checkPermissions(filepath.Dir(s.path), s.path, s.path+".lock")
+}
+
+func (s SingleFileStore) FixPermissions() error {
+	problems, err := s.CheckPermissions()
+	if err != nil {
+		return err
+	}
+	return fixPermissions(problems)
+}
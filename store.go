@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// Store is where vault files live. Vaults are stored as the bytes of their
+// json file under their file name; LoadVault, SaveVault and the views go
+// through the store set up at start, so they don't care which one it is:
+//   - FileStore, a directory with a json file per vault
+//   - SingleFileStore, every vault in one file
+//   - MemoryStore, nothing on disk, for tests
+type Store interface {
+	// List returns the names of the stored vaults.
+	List() ([]string, error)
+	// Load returns the vault stored under name. A missing vault is an error
+	// wrapping fs.ErrNotExist.
+	Load(name string) ([]byte, error)
+	// Save stores data under name if the vault stored there is still at
+	// revision, or if there is none yet. Otherwise nothing is written and a
+	// *VaultChangedError is returned. The check and the write can't be
+	// interleaved with another save, from this process or another one.
+	Save(name string, data []byte, revision uint64) error
+	// Delete removes the vault stored under name and whatever was kept of
+	// it.
+	Delete(name string) error
+	// Backup keeps a copy of the vault stored under name before it's
+	// migrated away from format version. An existing copy of that version is
+	// kept, it's the older one.
+	Backup(name string, version int) error
+	// DropCopies removes the copies kept of the vault stored under name, the
+	// previous one and the migration backups, but not the vault. Copies
+	// still open with the key slots they were made with, so they go when a
+	// slot is revoked.
+	DropCopies(name string) error
+}
+
+// store holds the vaults, see setupVaultsDir.
+var store Store
+
+// openStore returns the store at path: a SingleFileStore when it's a .db
+// file, a FileStore otherwise.
+func openStore(path string) Store {
+	if strings.EqualFold(filepath.Ext(path), ".db") {
+		return NewSingleFileStore(path)
+	}
+	return NewFileStore(path)
+}
+
+// checkStoredRevision makes sure the stored vault is still at revision. A
+// vault that isn't stored yet passes.
+func checkStoredRevision(stored []byte, err error, revision uint64) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var current struct {
+		Revision uint64 `json:"Revision"`
+	}
+	if err := json.Unmarshal(stored, &current); err != nil {
+		return err
+	}
+	if current.Revision != revision {
+		return &VaultChangedError{Revision: revision, OnDisk: current.Revision}
+	}
+	return nil
+}
+
+// sameKeySlots reports whether two stored vaults have the same key slots. A
+// copy of a vault is only kept across content edits: with the slots of
+// before, a revoked password, burned recovery code or removed slot would
+// still unwrap the vault key from it.
+func sameKeySlots(old, new []byte) bool {
+	var oldSlots, newSlots struct {
+		KeySlots json.RawMessage `json:"KeySlots"`
+	}
+	if json.Unmarshal(old, &oldSlots) != nil || json.Unmarshal(new, &newSlots) != nil {
+		return false
+	}
+	return bytes.Equal(oldSlots.KeySlots, newSlots.KeySlots)
+}
+
+// isBackupOf reports whether key, a backup name without extension, is a
+// migration backup of the vault name: <name>.v<version>.
+func isBackupOf(key, name string) bool {
+	version, ok := strings.CutPrefix(key, name+".v")
+	if !ok || version == "" {
+		return false
+	}
+	return strings.Trim(version, "0123456789") == ""
+}
+
+// notStored is the error for a vault a store doesn't hold.
+func notStored(name string) error {
+	return fmt.Errorf("vault %s: %w", name, fs.ErrNotExist)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// useMemoryStore points the store and the per-machine state at places that
+// go away with the test.
+func useMemoryStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	memory := NewMemoryStore()
+	previous := store
+	store = memory
+	t.Cleanup(func() { store = previous })
+	return memory
+}
+
+// newTestVault saves an empty vault opened with password to the store.
+func newTestVault(t *testing.T, password string) Vault {
+	t.Helper()
+	vaultKey, err := generateVaultKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(vaultKey.Destroy)
+	creds := Credentials{Password: password}
+	slot, err := NewKeySlot(slotPassword, "master password", creds.secretFor(KeySlot{Type: slotPassword}), vaultKey.Bytes(), cipherAES256GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultID, err := newVaultID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault, err := SaveVault(Vault{
+		FormatVersion: currentFormatVersion,
+		ID:            vaultID,
+		Cipher:        cipherAES256GCM,
+		Name:          "test",
+		KeySlots:      []KeySlot{slot},
+		Secrets:       []Secret{},
+		vaultKey:      vaultKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vault
+}
+
+func storedAt(revision uint64) []byte {
+	return []byte(fmt.Sprintf(`{"Revision":%d}`, revision))
+}
+
+func TestMemoryStoreSaveRevision(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   []byte
+		revision uint64
+		conflict bool
+	}{
+		{name: "new vault", revision: 0},
+		{name: "new vault at any revision", revision: 5},
+		{name: "up to date", stored: storedAt(2), revision: 2},
+		{name: "saved by someone else since", stored: storedAt(3), revision: 2, conflict: true},
+		{name: "stored copy rolled back", stored: storedAt(2), revision: 3, conflict: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore()
+			if tt.stored != nil {
+				s.vaults["a"] = tt.stored
+			}
+			err := s.Save("a", storedAt(tt.revision+1), tt.revision)
+
+			var changed *VaultChangedError
+			if !tt.conflict {
+				if err != nil {
+					t.Fatalf("Save() = %v, want nil", err)
+				}
+				if got, _ := s.Load("a"); string(got) != string(storedAt(tt.revision+1)) {
+					t.Errorf("stored %s after Save()", got)
+				}
+				return
+			}
+			if !errors.As(err, &changed) {
+				t.Fatalf("Save() = %v, want a *VaultChangedError", err)
+			}
+			if changed.Revision != tt.revision {
+				t.Errorf("conflict for revision %d, want %d", changed.Revision, tt.revision)
+			}
+			if got, _ := s.Load("a"); string(got) != string(tt.stored) {
+				t.Errorf("stored %s after a conflict, want %s", got, tt.stored)
+			}
+		})
+	}
+}
+
+func TestSaveVaultConflict(t *testing.T) {
+	useMemoryStore(t)
+	vault := newTestVault(t, "correct horse battery staple")
+
+	theirs := vault
+	theirs.Description = "theirs"
+	if _, err := SaveVault(theirs); err != nil {
+		t.Fatal(err)
+	}
+
+	ours := vault
+	ours.Description = "ours"
+	_, err := SaveVault(ours)
+	var changed *VaultChangedError
+	if !errors.As(err, &changed) || !errors.Is(err, ErrVaultChanged) {
+		t.Fatalf("SaveVault() = %v, want a *VaultChangedError", err)
+	}
+	if changed.Unsaved.Description != "ours" {
+		t.Errorf("Unsaved has description %q, want %q", changed.Unsaved.Description, "ours")
+	}
+	if changed.OnDisk != vault.Revision+1 {
+		t.Errorf("OnDisk = %d, want %d", changed.OnDisk, vault.Revision+1)
+	}
+}
+
+func TestFileStoreConcurrentSaves(t *testing.T) {
+	s := NewFileStore(t.TempDir())
+	if err := s.Save("a", storedAt(1), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every save starts from revision 1, only one of them may pass
+	const saves = 8
+	errs := make(chan error, saves)
+	for i := range saves {
+		go func() {
+			errs <- s.Save("a", []byte(fmt.Sprintf(`{"Revision":2,"Name":"%d"}`, i)), 1)
+		}()
+	}
+	passed := 0
+	for range saves {
+		err := <-errs
+		if err == nil {
+			passed++
+		} else if !errors.Is(err, ErrVaultChanged) {
+			t.Errorf("Save() = %v, want nil or ErrVaultChanged", err)
+		}
+	}
+	if passed != 1 {
+		t.Errorf("%d saves from the same revision passed, want 1", passed)
+	}
+}
+
+func TestLockFileRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".a.lock")
+	unlock, err := lockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiting behind a holder that removes the file, like Delete does, ends
+	// with a lock on a new file, which keeps the next process out
+	locked := make(chan func())
+	go func() {
+		unlock, err := lockFile(path)
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- unlock
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	unlockWaiter := <-locked
+
+	next := make(chan func())
+	go func() {
+		unlock, err := lockFile(path)
+		if err != nil {
+			t.Error(err)
+		}
+		next <- unlock
+	}()
+	select {
+	case unlock := <-next:
+		unlock()
+		t.Fatal("two lockFile() calls hold the lock at once")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockWaiter()
+	(<-next)()
+}
+
+func TestMemoryStoreCopies(t *testing.T) {
+	tests := []struct {
+		name string
+		// run gets a store holding vaults a and ab, each at revision 1,
+		// with a backup of format 1 of both.
+		run         func(s *MemoryStore) error
+		wantErr     error
+		wantVaults  []string
+		wantBackups []string
+	}{
+		{
+			name:        "backup of a missing vault",
+			run:         func(s *MemoryStore) error { return s.Backup("b", 1) },
+			wantErr:     fs.ErrNotExist,
+			wantVaults:  []string{"a", "ab"},
+			wantBackups: []string{"a.v1", "ab.v1"},
+		},
+		{
+			name:        "backup of another version",
+			run:         func(s *MemoryStore) error { return s.Backup("a", 2) },
+			wantVaults:  []string{"a", "ab"},
+			wantBackups: []string{"a.v1", "a.v2", "ab.v1"},
+		},
+		{
+			name:        "delete takes the backups",
+			run:         func(s *MemoryStore) error { return s.Delete("a") },
+			wantVaults:  []string{"ab"},
+			wantBackups: []string{"ab.v1"},
+		},
+		{
+			name:        "delete of a missing vault",
+			run:         func(s *MemoryStore) error { return s.Delete("b") },
+			wantErr:     fs.ErrNotExist,
+			wantVaults:  []string{"a", "ab"},
+			wantBackups: []string{"a.v1", "ab.v1"},
+		},
+		{
+			name:        "drop copies keeps the vault",
+			run:         func(s *MemoryStore) error { return s.DropCopies("a") },
+			wantVaults:  []string{"a", "ab"},
+			wantBackups: []string{"ab.v1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore()
+			for _, name := range []string{"a", "ab"} {
+				if err := s.Save(name, storedAt(1), 0); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Backup(name, 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := tt.run(s)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			vaults, _ := s.List()
+			if fmt.Sprint(vaults) != fmt.Sprint(tt.wantVaults) {
+				t.Errorf("vaults %v, want %v", vaults, tt.wantVaults)
+			}
+			if backups := slices.Sorted(maps.Keys(s.backups)); fmt.Sprint(backups) != fmt.Sprint(tt.wantBackups) {
+				t.Errorf("backups %v, want %v", backups, tt.wantBackups)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreBackupKeepsOldest(t *testing.T) {
+	s := NewMemoryStore()
+	if err := s.Save("a", storedAt(1), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Backup("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("a", storedAt(2), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Backup("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(s.backups["a.v1"]); got != string(storedAt(1)) {
+		t.Errorf("backup holds %s, want the first copy %s", got, storedAt(1))
+	}
+}
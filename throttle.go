@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Failed unlocks of a vault are counted in its state file, so guessing
+// passwords gets slower the more guesses are wrong and restarting ciphery
+// doesn't start over. The first few attempts are free for typos, after that
+// every failure doubles the wait before the next attempt.
+const (
+	freeUnlockAttempts = 3
+	firstUnlockDelay   = time.Second
+	maxUnlockDelay     = 10 * time.Minute
+
+	// Prefix of the throttle keys of vaults without an ID
+	fileThrottlePrefix = "file-"
+)
+
+// ErrUnlockThrottled is returned while a vault has to wait before the next
+// unlock attempt.
+var ErrUnlockThrottled = errors.New("too many failed attempts")
+
+// unlockDelay is the wait after the given number of failed attempts.
+func unlockDelay(failedAttempts int) time.Duration {
+	if failedAttempts < freeUnlockAttempts {
+		return 0
+	}
+	delay := firstUnlockDelay
+	for i := freeUnlockAttempts; i < failedAttempts && delay < maxUnlockDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxUnlockDelay)
+}
+
+// throttleKey is the state file the attempts of a vault are counted in.
+// Vaults from before IDs existed are counted by a hash of their file name,
+// which can be anything the store holds.
+func throttleKey(vault Vault) string {
+	if vault.ID != "" {
+		return vault.ID
+	}
+	sum := sha256.Sum256([]byte(vault.FileName()))
+	return fileThrottlePrefix + hex.EncodeToString(sum[:vaultIDSize])
+}
+
+// UnlockWait returns how long the vault has to wait before it may be
+// unlocked again, zero if it can be unlocked now.
+func UnlockWait(vault Vault) (time.Duration, error) {
+	state, err := loadVaultState(throttleKey(vault))
+	if err != nil {
+		return 0, err
+	}
+	wait := time.Until(state.LastFailedUnlock.Add(unlockDelay(state.FailedUnlocks)))
+	return max(wait, 0), nil
+}
+
+// ThrottledUnlock is UnlockVault for credentials someone typed in. It refuses
+// to try while the vault has to wait, counts failures and resets the count
+// on success. The number of failed attempts since the last unlock is
+// returned along with the vault key. Like the revision, the count can't be
+// kept if the state file can't be written, which doesn't stop the unlock.
+func ThrottledUnlock(vault Vault, creds Credentials) (*LockedBuffer, int, int, error) {
+	var vaultKey *LockedBuffer
+	slotIndex := -1
+	failedAttempts, err := throttled(vault, func() error {
+		var err error
+		vaultKey, slotIndex, err = UnlockVault(vault, creds)
+		return err
+	})
+	if err != nil {
+		return nil, -1, 0, err
+	}
+	return vaultKey, slotIndex, failedAttempts, nil
+}
+
+// ThrottledConfirm is ConfirmPassword counted and throttled like an unlock,
+// so confirming can't be used to guess faster.
+func ThrottledConfirm(vault Vault, creds Credentials) error {
+	_, err := throttled(vault, func() error {
+		return ConfirmPassword(vault, creds)
+	})
+	return err
+}
+
+// throttled runs attempt unless the vault has to wait, and counts it. It
+// returns the number of failed attempts before a successful one.
+func throttled(vault Vault, attempt func() error) (int, error) {
+	wait, err := UnlockWait(vault)
+	if err != nil {
+		return 0, err
+	}
+	if wait > 0 {
+		return 0, fmt.Errorf("%w, try again in %s", ErrUnlockThrottled, wait.Round(time.Second))
+	}
+
+	key := throttleKey(vault)
+	state, err := loadVaultState(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := attempt(); err != nil {
+		state.FailedUnlocks++
+		state.LastFailedUnlock = time.Now()
+		saveVaultState(key, state)
+		return 0, err
+	}
+
+	failedAttempts := state.FailedUnlocks
+	if failedAttempts > 0 {
+		state.FailedUnlocks = 0
+		state.LastFailedUnlock = time.Time{}
+		saveVaultState(key, state)
+	}
+	return failedAttempts, nil
+}
+
+// failedAttemptsNotice is shown after an unlock that had failed attempts
+// before it.
+func failedAttemptsNotice(failedAttempts int) string {
+	if failedAttempts == 1 {
+		return "1 failed attempt since last unlock"
+	}
+	return fmt.Sprintf("%d failed attempts since last unlock", failedAttempts)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Several ciphery processes may have the same vault open, each with its own
+// copy. The store checks that the stored vault is still at the revision the
+// copy was read at and writes it in one go, locked against other saves, so a
+// save never silently drops what another process saved in between. The view that
+// tried to save then offers to reload the vault or merge its change.
+
+// ErrVaultChanged is wrapped by the VaultChangedError SaveVault returns.
+var ErrVaultChanged = errors.New("vault was changed by another ciphery since it was read")
+
+// VaultChangedError is returned by SaveVault when the stored vault isn't at
+// the revision of the vault being saved anymore.
+type VaultChangedError struct {
+	// Unsaved is the vault that couldn't be saved.
+	Unsaved  Vault
+	Revision uint64
+	// OnDisk is the revision of the stored vault now.
+	OnDisk uint64
+}
+
+func (e *VaultChangedError) Error() string {
+	return fmt.Sprintf("%v (read at revision %d, now at %d)", ErrVaultChanged, e.Revision, e.OnDisk)
+}
+
+func (e *VaultChangedError) Unwrap() error {
+	return ErrVaultChanged
+}
+
+// ReloadVault reads an open vault from the store again, as another process
+// left it, and opens it with vaultKey.
+func ReloadVault(vault Vault, vaultKey *LockedBuffer) (Vault, error) {
+	loaded, err := LoadVault(vault.FileName())
+	if err != nil {
+		return vault, err
+	}
+	if err := VerifyVault(loaded, vaultKey.Bytes()); err != nil {
+		return vault, err
+	}
+	return OpenVault(loaded, vaultKey)
+}
+
+// CanMergeVaults reports whether ours only changed secrets compared to base,
+// which is all MergeVaultSecrets can carry over.
+func CanMergeVaults(base, ours Vault) bool {
+	base.Secrets, ours.Secrets = nil, nil
+	baseByte, err := json.Marshal(base)
+	if err != nil {
+		return false
+	}
+	oursByte, err := json.Marshal(ours)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(baseByte, oursByte)
+}
+
+// Kinds of MergeConflict.
+const (
+	// Edited on both sides, our edit is kept
+	conflictBothEdited = iota
+	// Deleted by us and edited by them, the secret is deleted
+	conflictDeletedHere
+	// Edited by us and deleted by them, our edit is kept
+	conflictDeletedThere
+)
+
+// MergeConflict is a secret both sides changed, so merging keeps only one of
+// the changes. Secret is the version that is kept, or the one deleted.
+type MergeConflict struct {
+	Kind   int
+	Secret Secret
+}
+
+// MergeVaultSecrets applies the changes ours made to the secrets of base on
+// top of theirs. Secrets are matched by ID: added ones are appended, deleted
+// ones removed and edited ones replaced. Where both changed the same secret
+// ours wins, and each such secret is returned as a conflict so the user can
+// be told before the merge is saved.
+func MergeVaultSecrets(base, ours, theirs Vault) (Vault, []MergeConflict) {
+	inBase := map[string]Secret{}
+	for _, secret := range base.Secrets {
+		inBase[secret.ID] = secret
+	}
+	inOurs := map[string]bool{}
+	for _, secret := range ours.Secrets {
+		inOurs[secret.ID] = true
+	}
+
+	var conflicts []MergeConflict
+	merged := theirs
+	merged.Secrets = slices.DeleteFunc(slices.Clone(theirs.Secrets), func(secret Secret) bool {
+		old, known := inBase[secret.ID]
+		if !known || inOurs[secret.ID] {
+			return false
+		}
+		if secret != old {
+			conflicts = append(conflicts, MergeConflict{Kind: conflictDeletedHere, Secret: secret})
+		}
+		return true
+	})
+	for _, secret := range ours.Secrets {
+		old, known := inBase[secret.ID]
+		if known && old == secret {
+			continue
+		}
+		i := slices.IndexFunc(merged.Secrets, func(s Secret) bool { return s.ID == secret.ID })
+		switch {
+		case known && i < 0:
+			conflicts = append(conflicts, MergeConflict{Kind: conflictDeletedThere, Secret: secret})
+		case known && merged.Secrets[i] != old && merged.Secrets[i] != secret:
+			conflicts = append(conflicts, MergeConflict{Kind: conflictBothEdited, Secret: secret})
+		}
+		if i >= 0 {
+			merged.Secrets[i] = secret
+		} else {
+			merged.Secrets = append(merged.Secrets, secret)
+		}
+	}
+	return merged, conflicts
+}
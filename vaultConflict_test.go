@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// secretsOf builds secrets from "id:content" pairs. The content stands in
+// for the ciphertexts, a changed one is an edit.
+func secretsOf(pairs ...string) []Secret {
+	secrets := []Secret{}
+	for _, pair := range pairs {
+		id, content, _ := strings.Cut(pair, ":")
+		secrets = append(secrets, Secret{ID: id, EncodedEncryptedText: [2]string{content, ""}})
+	}
+	return secrets
+}
+
+func pairsOf(secrets []Secret) []string {
+	pairs := []string{}
+	for _, secret := range secrets {
+		pairs = append(pairs, secret.ID+":"+secret.EncodedEncryptedText[0])
+	}
+	return pairs
+}
+
+func TestMergeVaultSecrets(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs []string
+		want               []string
+		// conflicts as "kind:id", kind one of both-edited, deleted-here and deleted-there
+		conflicts []string
+	}{
+		{
+			name:   "nothing changed",
+			base:   []string{"a:1", "b:1"},
+			ours:   []string{"a:1", "b:1"},
+			theirs: []string{"a:1", "b:1"},
+			want:   []string{"a:1", "b:1"},
+		},
+		{
+			name:   "both added",
+			base:   []string{"a:1"},
+			ours:   []string{"a:1", "b:1"},
+			theirs: []string{"a:1", "c:1"},
+			want:   []string{"a:1", "c:1", "b:1"},
+		},
+		{
+			name:   "we deleted",
+			base:   []string{"a:1", "b:1"},
+			ours:   []string{"a:1"},
+			theirs: []string{"a:1", "b:1"},
+			want:   []string{"a:1"},
+		},
+		{
+			name:   "they deleted",
+			base:   []string{"a:1", "b:1"},
+			ours:   []string{"a:1", "b:1"},
+			theirs: []string{"b:1"},
+			want:   []string{"b:1"},
+		},
+		{
+			name:   "edits to different secrets",
+			base:   []string{"a:1", "b:1"},
+			ours:   []string{"a:2", "b:1"},
+			theirs: []string{"a:1", "b:2"},
+			want:   []string{"a:2", "b:2"},
+		},
+		{
+			name:      "both edited the same secret",
+			base:      []string{"a:1"},
+			ours:      []string{"a:2"},
+			theirs:    []string{"a:3"},
+			want:      []string{"a:2"},
+			conflicts: []string{"both-edited:a"},
+		},
+		{
+			name:      "our edit wins over their delete",
+			base:      []string{"a:1", "b:1"},
+			ours:      []string{"a:1", "b:2"},
+			theirs:    []string{"a:1"},
+			want:      []string{"a:1", "b:2"},
+			conflicts: []string{"deleted-there:b"},
+		},
+		{
+			name:      "our delete of a secret they edited",
+			base:      []string{"a:1", "b:1"},
+			ours:      []string{"a:1"},
+			theirs:    []string{"a:1", "b:2"},
+			want:      []string{"a:1"},
+			conflicts: []string{"deleted-here:b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := Vault{Secrets: secretsOf(tt.base...)}
+			ours := Vault{Secrets: secretsOf(tt.ours...), Description: "ours"}
+			theirs := Vault{Secrets: secretsOf(tt.theirs...), Description: "theirs", Revision: 7}
+
+			merged, conflicts := MergeVaultSecrets(base, ours, theirs)
+			if got := pairsOf(merged.Secrets); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("merged secrets %v, want %v", got, tt.want)
+			}
+			kinds := map[int]string{conflictBothEdited: "both-edited", conflictDeletedHere: "deleted-here", conflictDeletedThere: "deleted-there"}
+			got := []string{}
+			for _, conflict := range conflicts {
+				got = append(got, kinds[conflict.Kind]+":"+conflict.Secret.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.conflicts) {
+				t.Errorf("conflicts %v, want %v", got, tt.conflicts)
+			}
+			// Everything but the secrets is theirs
+			if merged.Description != "theirs" || merged.Revision != 7 {
+				t.Errorf("merged vault has description %q and revision %d, want theirs", merged.Description, merged.Revision)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Secrets are authenticated one by one, which doesn't notice secrets being
+// deleted or reordered, or the whole file being swapped for an older copy.
+// So every save also bumps the revision of the vault and writes a MAC over
+// the whole file, keyed with the vault key. The highest revision seen of
+// each vault is remembered outside the vaults folder to catch rollbacks.
+
+// ErrVaultTampered is wrapped by the errors VerifyVault returns.
+var ErrVaultTampered = errors.New("vault file was tampered with")
+
+// vaultMAC returns the MAC of vault as it is written to its file. The MAC
+// field itself is left out.
+func vaultMAC(vault Vault, vaultKey []byte) (string, error) {
+	vault.MAC = ""
+	canonical, err := json.Marshal(vault)
+	if err != nil {
+		return "", err
+	}
+
+	macKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, vaultKey, nil, []byte("ciphery vault mac")), macKey); err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(canonical)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// VerifyVault checks the MAC of a vault as it was read from its file and that
+// it isn't older than the last revision seen. Only format 1 files may lack
+// the MAC, and only if none was seen for the vault before. A file with vault
+// or secret IDs is format 2 or later, so an old format version on it doesn't
+// excuse a stripped MAC.
+func VerifyVault(vault Vault, vaultKey []byte) error {
+	state, err := loadVaultState(vault.ID)
+	if err != nil {
+		return err
+	}
+
+	if vault.MAC == "" {
+		if !isFormatOne(vault) || state.Revision > 0 {
+			return fmt.Errorf("%w: its integrity check is missing", ErrVaultTampered)
+		}
+		return nil
+	}
+
+	expected, err := vaultMAC(vault, vaultKey)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(vault.MAC)) {
+		return fmt.Errorf("%w: secrets or settings were changed, removed or reordered outside of ciphery", ErrVaultTampered)
+	}
+	if vault.Revision < state.Revision {
+		return fmt.Errorf("%w: it is at revision %d but revision %d was seen before, it may have been rolled back", ErrVaultTampered, vault.Revision, state.Revision)
+	}
+
+	recordRevision(vault.ID, vault.Revision)
+	return nil
+}
+
+// isFormatOne reports whether a vault file is of the first format, which
+// had no format version, no IDs, no sealing and no MAC.
+func isFormatOne(vault Vault) bool {
+	if vault.FormatVersion > 1 || vault.ID != "" || vault.Sealed {
+		return false
+	}
+	for _, secret := range vault.Secrets {
+		if secret.ID != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// vaultState is what's remembered about a vault outside of its file.
+type vaultState struct {
+	Revision uint64 `json:"Revision"`
+	// Failed unlock attempts since the last unlock, see throttle.go
+	FailedUnlocks    int       `json:"FailedUnlocks,omitempty"`
+	LastFailedUnlock time.Time `json:"LastFailedUnlock"`
+}
+
+// vaultStatePath is ciphery/state/<vaultID>.json in the user config
+// directory, next to the config file. It stays on this machine while the
+// vaults directory may be synced, so a rolled back vault can't bring its
+// state along. vaultID is a vault ID or a throttle key, anything else is
+// refused so it can't name a file outside of the state directory.
+func vaultStatePath(vaultID string) (string, error) {
+	if !validVaultID(strings.TrimPrefix(vaultID, fileThrottlePrefix)) {
+		return "", fmt.Errorf("invalid vault ID %q for the vault state", vaultID)
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "ciphery", "state", vaultID+".json"), nil
+}
+
+// loadVaultState reads the state of a vault. Vaults without one, or without
+// an ID, get an empty state.
+func loadVaultState(vaultID string) (vaultState, error) {
+	var state vaultState
+	if vaultID == "" {
+		return state, nil
+	}
+	path, err := vaultStatePath(vaultID)
+	if err != nil {
+		return state, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func saveVaultState(vaultID string, state vaultState) error {
+	path, err := vaultStatePath(vaultID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// recordRevision remembers revision as seen for the vault unless a newer one
+// was seen already. Failing to do so only weakens the rollback check, so
+// errors are ignored.
+func recordRevision(vaultID string, revision uint64) {
+	if vaultID == "" {
+		return
+	}
+	state, err := loadVaultState(vaultID)
+	if err != nil || state.Revision >= revision {
+		return
+	}
+	state.Revision = revision
+	saveVaultState(vaultID, state)
+}
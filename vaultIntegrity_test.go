@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyVaultMissingMAC(t *testing.T) {
+	tests := []struct {
+		name     string
+		strip    func(vault *Vault)
+		tampered bool
+	}{
+		{name: "intact"},
+		{
+			name:  "format 1",
+			strip: func(vault *Vault) { *vault = vaultAtVersion(t, 1, vault.vaultKey) },
+		},
+		{
+			name:     "format 3 without its MAC",
+			strip:    func(vault *Vault) { vault.MAC = "" },
+			tampered: true,
+		},
+		{
+			name:     "format 2",
+			strip:    func(vault *Vault) { vault.MAC, vault.FormatVersion = "", 2 },
+			tampered: true,
+		},
+		{
+			name:     "format version removed",
+			strip:    func(vault *Vault) { vault.MAC, vault.FormatVersion = "", 0 },
+			tampered: true,
+		},
+		{
+			name: "format version and vault ID removed",
+			strip: func(vault *Vault) {
+				vault.MAC, vault.FormatVersion, vault.ID = "", 0, ""
+			},
+			tampered: true,
+		},
+		{
+			name: "sealed",
+			strip: func(vault *Vault) {
+				vault.MAC, vault.FormatVersion, vault.ID, vault.Secrets = "", 0, "", nil
+				vault.Sealed = true
+			},
+			tampered: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			vault := newTestVault(t, "correct horse battery staple")
+			secretID, err := newSecretID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret, err := EncryptSecret(vault, secretID, "mail", "hunter2", vault.vaultKey.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			vault.Secrets = append(vault.Secrets, secret)
+			if vault, err = SaveVault(vault); err != nil {
+				t.Fatal(err)
+			}
+			// On a machine that never saw the vault, so no revision was
+			// recorded that gives a stripped MAC away
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("HOME", t.TempDir())
+			if tt.strip != nil {
+				tt.strip(&vault)
+			}
+
+			err = VerifyVault(vault, vault.vaultKey.Bytes())
+			if tt.tampered != errors.Is(err, ErrVaultTampered) || !tt.tampered && err != nil {
+				t.Errorf("VerifyVault() = %v, tampered %v", err, tt.tampered)
+			}
+		})
+	}
+}
+
+func TestVaultIDPaths(t *testing.T) {
+	useMemoryStore(t)
+	vaultID, err := newVaultID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		id    string
+		valid bool
+	}{
+		{name: "no ID", id: "", valid: true},
+		{name: "generated", id: vaultID, valid: true},
+		{name: "parent directories", id: "../../x"},
+		{name: "parent directories of the right length", id: "../../" + vaultID[6:]},
+		{name: "uppercase", id: strings.ToUpper(vaultID)},
+		{name: "too short", id: vaultID[:12]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeVault([]byte(fmt.Sprintf(`{"FormatVersion":3,"ID":%q}`, tt.id)))
+			if tt.valid != (err == nil) {
+				t.Errorf("decodeVault() = %v, valid %v", err, tt.valid)
+			}
+			if tt.id == "" {
+				return
+			}
+			if _, err := vaultStatePath(tt.id); tt.valid != (err == nil) {
+				t.Errorf("vaultStatePath() = %v, valid %v", err, tt.valid)
+			}
+			// Sealed vaults are named after their ID, a short one mustn't panic
+			_ = Vault{Sealed: true, ID: tt.id}.FileName()
+		})
+	}
+
+	// Vaults without an ID are throttled by their store name, whatever it is
+	stateDir, err := vaultStatePath(vaultID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := vaultStatePath(throttleKey(Vault{fileName: "../../x"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != filepath.Dir(stateDir) {
+		t.Errorf("throttle state of ../../x at %s, want it in %s", path, filepath.Dir(stateDir))
+	}
+}
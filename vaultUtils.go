@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrWrongPassword = errors.New("wrong master password")
+
+// decodeVault parses a vault file and brings older layouts up to date. Files
+// from a newer format version are refused.
+func decodeVault(vaultByte []byte) (Vault, error) {
+	var vault Vault
+	if err := checkFormatVersion(vaultByte); err != nil {
+		return vault, err
+	}
+	if err := json.Unmarshal(vaultByte, &vault); err != nil {
+		return vault, err
+	}
+	if vault.FormatVersion == 0 {
+		vault.FormatVersion = 1
+	}
+	// The ID names the per-machine state file before the MAC can be
+	// checked, so nothing but a generated one is taken
+	if vault.ID != "" && !validVaultID(vault.ID) {
+		return vault, errors.New("vault file has an invalid vault ID")
+	}
+	normalizeKeySlots(&vault)
+	return vault, nil
+}
+
+// LoadVault reads a vault from the store.
+func LoadVault(vaultName string) (Vault, error) {
+	vaultByte, err := store.Load(vaultName)
+	if err != nil {
+		return Vault{}, err
+	}
+	vault, err := decodeVault(vaultByte)
+	vault.fileName = vaultName
+	return vault, err
+}
+
+// SaveVault writes the vault to the store. The vault has to be open since
+// sealing and authenticating it takes its key. Every save gets a new
+// revision; the vault is returned as it is now stored. If another process
+// saved the vault since it was read, nothing is written and a
+// *VaultChangedError is returned.
+func SaveVault(vault Vault) (Vault, error) {
+	if vault.vaultKey == nil {
+		return vault, errors.New("vault has to be unlocked to be saved")
+	}
+
+	state, err := loadVaultState(vault.ID)
+	if err != nil {
+		return vault, err
+	}
+
+	saved := vault
+	saved.Revision = max(vault.Revision, state.Revision) + 1
+	stored := saved
+	if stored.Sealed {
+		stored, err = sealContents(stored)
+		if err != nil {
+			return vault, err
+		}
+	}
+	stored.MAC, err = vaultMAC(stored, vault.vaultKey.Bytes())
+	if err != nil {
+		return vault, err
+	}
+	saved.MAC = stored.MAC
+
+	vaultByte, err := json.Marshal(stored)
+	if err != nil {
+		return vault, err
+	}
+	var changed *VaultChangedError
+	if err := store.Save(vault.FileName(), vaultByte, vault.Revision); errors.As(err, &changed) {
+		changed.Unsaved = vault
+		return vault, changed
+	} else if err != nil {
+		return vault, err
+	}
+	recordRevision(saved.ID, saved.Revision)
+	return saved, nil
+}
+
+// CipherName returns the cipher new ciphertexts of the vault are made with.
+func (v Vault) CipherName() string {
+	if v.Cipher == "" {
+		return cipherAES256GCM
+	}
+	return v.Cipher
+}
+
+// Vault IDs are vaultIDSize random bytes in lowercase hex.
+const vaultIDSize = 16
+
+func newVaultID() (string, error) {
+	return newRandomID(vaultIDSize)
+}
+
+// validVaultID reports whether id looks like one newVaultID made.
+func validVaultID(id string) bool {
+	if len(id) != 2*vaultIDSize {
+		return false
+	}
+	for _, c := range id {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newSecretID() (string, error) {
+	return newRandomID(8)
+}
+
+// secretAAD returns the associated data the name and text of a secret are
+// authenticated with. It ties them to the vault, the secret and the field, so
+// ciphertexts can't be swapped between secrets or copied into another vault.
+// Vaults before format 2 didn't use any.
+func secretAAD(vault Vault, secretID string) [2][]byte {
+	if vault.FormatVersion < 2 {
+		return [2][]byte{}
+	}
+	return [2][]byte{
+		[]byte(fmt.Sprintf("ciphery/%s/%s/name", vault.ID, secretID)),
+		[]byte(fmt.Sprintf("ciphery/%s/%s/text", vault.ID, secretID)),
+	}
+}
+
+// EncryptSecret encrypts a secret that belongs to vault.
+func EncryptSecret(vault Vault, secretID, secretName, secretText string, vaultKey []byte) (Secret, error) {
+	var err error
+	secret := Secret{ID: secretID, Cipher: vault.CipherName()}
+	secret.EncodedEncryptedName, secret.EncodedEncryptedText, err = EncryptSecretData(secretName, secretText, vaultKey, secretAAD(vault, secretID), secret.Cipher)
+	return secret, err
+}
+
+// DecryptSecret decrypts a secret of vault, returning its name and text.
+func DecryptSecret(vault Vault, secret Secret, vaultKey []byte) (string, string, error) {
+	return DecryptSecretData(secret.EncodedEncryptedName, secret.EncodedEncryptedText, vaultKey, secretAAD(vault, secret.ID), secret.Cipher)
+}
+
+// RevealSecret decrypts a secret of vault like DecryptSecret, but puts its
+// text straight into locked memory. The buffer belongs to the key of the open
+// vault and is wiped when the vault is locked.
+func RevealSecret(vault Vault, secret Secret, vaultKey []byte) (string, *LockedBuffer, error) {
+	secretName, secretText, err := decryptSecretFields(secret.EncodedEncryptedName, secret.EncodedEncryptedText, vaultKey, secretAAD(vault, secret.ID), secret.Cipher)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(secretName), LockBytes(secretText).ownedBy(vault.vaultKey), nil
+}
+
+// reencryptSecret decrypts a secret of from with fromKey and encrypts it for
+// to with toKey under secretID, in the cipher of to. The plaintext stays in
+// locked memory in between and is wiped afterwards.
+func reencryptSecret(from, to Vault, secret Secret, secretID string, fromKey, toKey []byte) (Secret, error) {
+	secretName, secretText, err := decryptSecretFields(secret.EncodedEncryptedName, secret.EncodedEncryptedText, fromKey, secretAAD(from, secret.ID), secret.Cipher)
+	if err != nil {
+		return secret, fmt.Errorf("decrypting: %w", err)
+	}
+	lockedName, lockedText := LockBytes(secretName), LockBytes(secretText)
+	defer lockedName.Destroy()
+	defer lockedText.Destroy()
+
+	reencrypted := Secret{ID: secretID, Cipher: to.CipherName(), Sensitive: secret.Sensitive}
+	aad := secretAAD(to, secretID)
+	reencrypted.EncodedEncryptedName, err = encryptSecretField(lockedName.Bytes(), toKey, aad[0], reencrypted.Cipher)
+	if err != nil {
+		return secret, fmt.Errorf("encrypting: %w", err)
+	}
+	reencrypted.EncodedEncryptedText, err = encryptSecretField(lockedText.Bytes(), toKey, aad[1], reencrypted.Cipher)
+	if err != nil {
+		return secret, fmt.Errorf("encrypting: %w", err)
+	}
+	return reencrypted, nil
+}
+
+// EditSecret changes the secret at index of an open vault and saves it. Only
+// the fields that changed are encrypted again, under fresh nonces and the
+// cipher the secret already uses. The secret keeps its ID and its place.
+func EditSecret(vault Vault, index int, secretName, secretText string, sensitive bool) (Vault, error) {
+	if index < 0 || index >= len(vault.Secrets) {
+		return vault, fmt.Errorf("no secret %d in the vault", index+1)
+	}
+	secret := vault.Secrets[index]
+	aad := secretAAD(vault, secret.ID)
+	oldName, oldText, err := decryptSecretFields(secret.EncodedEncryptedName, secret.EncodedEncryptedText, vault.vaultKey.Bytes(), aad, secret.Cipher)
+	if err != nil {
+		return vault, err
+	}
+	defer wipeBytes(oldText)
+
+	changed := false
+	if secretName != string(oldName) {
+		secret.EncodedEncryptedName, err = EncryptSecretField(secretName, vault.vaultKey.Bytes(), aad[0], secret.Cipher)
+		if err != nil {
+			return vault, err
+		}
+		changed = true
+	}
+	if secretText != string(oldText) {
+		secret.EncodedEncryptedText, err = EncryptSecretField(secretText, vault.vaultKey.Bytes(), aad[1], secret.Cipher)
+		if err != nil {
+			return vault, err
+		}
+		changed = true
+	}
+	if sensitive != secret.Sensitive {
+		secret.Sensitive = sensitive
+		changed = true
+	}
+	if !changed {
+		return vault, nil
+	}
+
+	edited := vault
+	edited.Secrets = append([]Secret{}, vault.Secrets...)
+	edited.Secrets[index] = secret
+	saved, err := SaveVault(edited)
+	if err != nil {
+		return vault, err
+	}
+	return saved, nil
+}
+
+// UpgradeSlotKDF re-wraps the vault key in the given slot under the default
+// key derivation parameters and saves the vault. Secrets aren't touched since
+// the vault key itself doesn't change.
+func UpgradeSlotKDF(vault Vault, slotIndex int, secret []byte, vaultKey *LockedBuffer) (Vault, error) {
+	slot, err := vault.KeySlots[slotIndex].rewrap(secret, vaultKey.Bytes(), vault.CipherName())
+	if err != nil {
+		return vault, err
+	}
+
+	upgraded, err := OpenVault(vault, vaultKey)
+	if err != nil {
+		return vault, err
+	}
+	upgraded.KeySlots = append([]KeySlot{}, vault.KeySlots...)
+	upgraded.KeySlots[slotIndex] = slot
+
+	return SaveVault(upgraded)
+}
+
+// ChangeVaultPassword finds the password slot the old credentials open and
+// re-wraps the existing vault key in it under newPassword. A slot that needs a
+// keyfile keeps needing the same keyfile. Secrets stay encrypted with the same
+// vault key so they don't need to be touched. The copies the store kept of the
+// vault are removed, they'd still open with the old password. The old
+// credentials are checked like an unlock, counted and throttled. The vault
+// comes back with the key it was passed with, or none.
+func ChangeVaultPassword(vault Vault, oldCreds Credentials, newPassword string) (Vault, error) {
+	slotIndex := -1
+	var vaultKey []byte
+	_, err := throttled(vault, func() error {
+		for i, slot := range vault.KeySlots {
+			if slot.Type != slotPassword {
+				continue
+			}
+			if key, ok := slot.unlock(oldCreds); ok {
+				slotIndex, vaultKey = i, key
+				return nil
+			}
+		}
+		return ErrWrongPassword
+	})
+	if err != nil {
+		return vault, err
+	}
+
+	// The copies kept of the vault still open with the old password
+	if err := dropRevokedCopies(vault); err != nil {
+		return vault, err
+	}
+	lockedVaultKey := LockBytes(vaultKey)
+	defer lockedVaultKey.Destroy()
+	newCreds := Credentials{Password: newPassword, Keyfile: oldCreds.Keyfile}
+	changed, err := UpgradeSlotKDF(vault, slotIndex, newCreds.secretFor(vault.KeySlots[slotIndex]), lockedVaultKey)
+	changed.vaultKey = vault.vaultKey
+	return changed, err
+}
+
+// RotateVaultKey replaces the vault key with a freshly generated one. Every
+// secret is decrypted with the current key and encrypted again with the new
+// one. The new key is wrapped again in every slot creds can open; the other
+// slots are removed since their secrets aren't known here, and returned so
+// the caller can tell which. Recovery codes among them can be replaced with
+// GenerateRecoveryCodes. Nothing is written until all secrets are
+// re-encrypted, so a failed rotation leaves the vault file as it was.
+// progress is called after each secret if it isn't nil, an error from it
+// stops the rotation. creds are checked like an unlock, counted and
+// throttled.
+func RotateVaultKey(vault Vault, creds Credentials, progress func(done, total int) error) (Vault, *LockedBuffer, []KeySlot, error) {
+	oldVaultKey, _, _, err := ThrottledUnlock(vault, creds)
+	if err != nil {
+		return vault, nil, nil, err
+	}
+	defer oldVaultKey.Destroy()
+
+	// Older vaults are brought up to date along the way
+	current, err := OpenVault(vault, oldVaultKey)
+	if err != nil {
+		return vault, nil, nil, err
+	}
+	current, err = migrateVault(current, oldVaultKey.Bytes())
+	if err != nil {
+		return vault, nil, nil, err
+	}
+
+	newVaultKey, err := generateVaultKey()
+	if err != nil {
+		return vault, nil, nil, err
+	}
+
+	rotated := current
+	rotated.vaultKey = newVaultKey
+	rotated.Secrets = make([]Secret, len(current.Secrets))
+	for i, secret := range current.Secrets {
+		rotated.Secrets[i], err = reencryptSecret(current, rotated, secret, secret.ID, oldVaultKey.Bytes(), newVaultKey.Bytes())
+		if err != nil {
+			newVaultKey.Destroy()
+			return vault, nil, nil, fmt.Errorf("secret %d: %w", i+1, err)
+		}
+		if progress != nil {
+			if err := progress(i+1, len(current.Secrets)); err != nil {
+				newVaultKey.Destroy()
+				return vault, nil, nil, err
+			}
+		}
+	}
+
+	rotated.KeySlots = []KeySlot{}
+	var dropped []KeySlot
+	for _, slot := range vault.KeySlots {
+		if !slot.opens(creds) {
+			dropped = append(dropped, slot)
+			continue
+		}
+		// Wrapped again under the normalized password
+		secret := creds.secretFor(slot)
+		slot, err = slot.rewrap(secret, newVaultKey.Bytes(), rotated.CipherName())
+		if err != nil {
+			newVaultKey.Destroy()
+			return vault, nil, nil, err
+		}
+		rotated.KeySlots = append(rotated.KeySlots, slot)
+	}
+
+	rotated, err = SaveVault(rotated)
+	if err != nil {
+		newVaultKey.Destroy()
+		return vault, nil, nil, err
+	}
+	return rotated, newVaultKey, dropped, nil
+}
+
+// ConvertVaultCipher encrypts every secret of an open vault again with
+// cipherName and makes it the cipher of the vault. The key slots creds open
+// are wrapped again with it; the others keep their cipher until they are
+// wrapped again, since that needs their secret. progress is called after each
+// secret if it isn't nil, an error from it stops the conversion.
+func ConvertVaultCipher(vault Vault, creds Credentials, cipherName string, progress func(done, total int) error) (Vault, error) {
+	if !validCipher(cipherName) {
+		return vault, fmt.Errorf("unknown cipher %q", cipherName)
+	}
+
+	var err error
+	converted := vault
+	converted.Cipher = cipherName
+	converted.Secrets = make([]Secret, len(vault.Secrets))
+	for i, secret := range vault.Secrets {
+		converted.Secrets[i], err = reencryptSecret(vault, converted, secret, secret.ID, vault.vaultKey.Bytes(), vault.vaultKey.Bytes())
+		if err != nil {
+			return vault, fmt.Errorf("secret %d: %w", i+1, err)
+		}
+		if progress != nil {
+			if err := progress(i+1, len(vault.Secrets)); err != nil {
+				return vault, err
+			}
+		}
+	}
+
+	converted.KeySlots = append([]KeySlot{}, vault.KeySlots...)
+	for i, slot := range vault.KeySlots {
+		if !slot.opens(creds) {
+			continue
+		}
+		secret := creds.secretFor(slot)
+		converted.KeySlots[i], err = slot.rewrap(secret, vault.vaultKey.Bytes(), cipherName)
+		if err != nil {
+			return vault, err
+		}
+	}
+
+	saved, err := SaveVault(converted)
+	if err != nil {
+		return vault, err
+	}
+	return saved, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRotateVaultKeyDroppedSlots(t *testing.T) {
+	useMemoryStore(t)
+	creds := Credentials{Password: "correct horse battery staple"}
+	vault := newTestVault(t, creds.Password)
+	vault, codes, err := GenerateRecoveryCodes(vault, vault.vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, vaultKey, dropped, err := RotateVaultKey(vault, creds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vaultKey.Destroy()
+	if len(dropped) != len(codes) || !hasRecoverySlot(dropped) {
+		t.Fatalf("dropped %d slots, want the %d recovery codes", len(dropped), len(codes))
+	}
+	for _, slot := range dropped {
+		if slot.Type != slotRecovery {
+			t.Errorf("dropped %s, want only recovery codes", slot.describe())
+		}
+	}
+	if len(rotated.KeySlots) != 1 || rotated.KeySlots[0].ID != vault.KeySlots[0].ID {
+		t.Errorf("kept %d slots, want only the password slot", len(rotated.KeySlots))
+	}
+
+	// The rotated vault takes new recovery codes right away
+	rotated, codes, err = GenerateRecoveryCodes(rotated, vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, _, err := UnlockVault(rotated, Credentials{Password: codes[0]})
+	if err != nil {
+		t.Fatalf("UnlockVault() with a new recovery code = %v", err)
+	}
+	defer recovered.Destroy()
+	if string(recovered.Bytes()) != string(vaultKey.Bytes()) {
+		t.Error("the new recovery code opens a different key")
+	}
+}
+
+func TestPasswordChecksThrottled(t *testing.T) {
+	tests := []struct {
+		name  string
+		check func(vault Vault, creds Credentials) error
+	}{
+		{
+			name: "change password",
+			check: func(vault Vault, creds Credentials) error {
+				_, err := ChangeVaultPassword(vault, creds, "gravel wombat tundra lantern")
+				return err
+			},
+		},
+		{
+			name: "rotate key",
+			check: func(vault Vault, creds Credentials) error {
+				_, vaultKey, _, err := RotateVaultKey(vault, creds, nil)
+				vaultKey.Destroy()
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			vault := newTestVault(t, "correct horse battery staple")
+			wrong := Credentials{Password: "wrong"}
+			for range freeUnlockAttempts {
+				if err := tt.check(vault, wrong); !errors.Is(err, ErrWrongPassword) {
+					t.Fatalf("got %v, want ErrWrongPassword", err)
+				}
+			}
+			// Counted together with unlocks, the right password has to wait too
+			if _, _, _, err := ThrottledUnlock(vault, wrong); !errors.Is(err, ErrUnlockThrottled) {
+				t.Errorf("ThrottledUnlock() = %v, want ErrUnlockThrottled", err)
+			}
+			if err := tt.check(vault, Credentials{Password: "correct horse battery staple"}); !errors.Is(err, ErrUnlockThrottled) {
+				t.Errorf("got %v, want ErrUnlockThrottled", err)
+			}
+		})
+	}
+}
+
+func TestRotateVaultKeyStopped(t *testing.T) {
+	useMemoryStore(t)
+	creds := Credentials{Password: "correct horse battery staple"}
+	vault := newTestVault(t, creds.Password)
+	secretID, err := newSecretID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := EncryptSecret(vault, secretID, "mail", "hunter2", vault.vaultKey.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.Secrets = append(vault.Secrets, secret)
+	if vault, err = SaveVault(vault); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := errors.New("quitting")
+	_, vaultKey, _, err := RotateVaultKey(vault, creds, func(done, total int) error { return stop })
+	if !errors.Is(err, stop) || vaultKey != nil {
+		t.Fatalf("RotateVaultKey() = %v, %v, want the progress error and no key", vaultKey, err)
+	}
+	stored, err := LoadVault(vault.FileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Revision != vault.Revision {
+		t.Errorf("stopped rotation saved the vault at revision %d, want %d", stored.Revision, vault.Revision)
+	}
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
@@ -19,10 +20,19 @@ type VaultModel struct {
 	mainModel             *mainModel
 	vault                 Vault
 	decryptedVaultSecrets []DecryptedSecret
-	decryptedVaultKey     []byte
+	decryptedVaultKey     *LockedBuffer
 	errorMsg              string
 	confirmationMsg       string
 	cursor                int
+
+	// revealed is the secret shown unmasked until revealUntil, -1 if none.
+	revealed    int
+	revealUntil time.Time
+	// confirming is what to do once the master password was given again, see
+	// reveal.go.
+	confirming      int
+	confirmPassword textinput.Model
+	confirmKeyfile  textinput.Model
 }
 
 func InitialVaultModel(mainmdl *mainModel) VaultModel {
@@ -30,6 +40,7 @@ func InitialVaultModel(mainmdl *mainModel) VaultModel {
 		keys:      keysVault,
 		help:      help.New(),
 		mainModel: mainmdl,
+		revealed:  -1,
 	}
 	return m
 }
@@ -53,15 +64,19 @@ func (m VaultModel) View() string {
 			if m.cursor == i {
 				style = listItemHighlightStyle
 			}
-			v += style.Render(fmt.Sprintf("%s\n%s", secret.SecretName, listItemDescriptionStyle.Render(secret.SecretText)))
+			v += style.Render(fmt.Sprintf("%s\n%s", secret.SecretName, listItemDescriptionStyle.Render(m.secretTextView(i))))
 			v += "\n"
 		}
 		s += listStyle.Render(v)
 		s += "\n"
 	}
 
+	if m.confirming != confirmNone {
+		s += m.confirmView()
+	}
 	s += errorStyle.Render(fmt.Sprintf("%s\n", m.errorMsg))
 	s += confirmationStyle.Render(fmt.Sprintf("%s\n", m.confirmationMsg))
+	s += listItemDescriptionStyle.Render(fmt.Sprintf("%s\n", clipboardNotice()))
 
 	helpView := m.help.View(m.keys)
 	s += helpStyle.Render(helpView)
@@ -70,8 +85,15 @@ func (m VaultModel) View() string {
 }
 
 func (m VaultModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if model, cmd, ok := m.mainModel.autoLock(m, msg, m.vault, false); ok {
+		return model, cmd
+	}
+
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.confirming != confirmNone {
+			return m.updateConfirm(msg)
+		}
 		switch {
 		case key.Matches(msg, m.keys.Quit):
 			return m, tea.Quit
@@ -80,15 +102,27 @@ func (m VaultModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.Up):
 			if m.cursor > 0 {
 				m.cursor--
+				m.revealed = -1
 			}
 		case key.Matches(msg, m.keys.Down):
 			if m.cursor < len(m.vault.Secrets)-1 {
 				m.cursor++
+				m.revealed = -1
 			}
+		case key.Matches(msg, m.keys.Reveal):
+			if len(m.vault.Secrets) == 0 {
+				return m, nil
+			}
+			return m.toggleReveal()
 		case key.Matches(msg, m.keys.Back):
+			// Leaving the vault locks it
+			DestroyVaultBuffers(m.vault.vaultKey)
+			ClearClipboard()
+			m.mainModel.stopAutoLock()
 			m.mainModel.viewState = vaultsView
 			return m.mainModel.vaultsView, tea.Batch(tea.WindowSize(), m.mainModel.vaultsView.Init())
 		case key.Matches(msg, m.keys.Create):
+			m.wipeSecrets()
 			m.mainModel.viewState = createSecretView
 			return m.mainModel.createSecretView, tea.Batch(tea.WindowSize(), textinput.Blink, m.mainModel.createSecretView.Init(), SendDecryptedVaultKeyCmd(m.decryptedVaultKey), SendVaultCmd(m.vault))
 		case key.Matches(msg, m.keys.Delete):
@@ -96,48 +130,151 @@ func (m VaultModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 			return m.handleDelete()
+		case key.Matches(msg, m.keys.Edit):
+			if len(m.vault.Secrets) == 0 {
+				return m, nil
+			}
+			if m.needsConfirm() {
+				return m.startConfirm(confirmEdit)
+			}
+			return m.handleEdit()
+		case key.Matches(msg, m.keys.CopyText) || key.Matches(msg, m.keys.CopyName):
+			if len(m.vault.Secrets) == 0 {
+				return m, nil
+			}
+			copyName := key.Matches(msg, m.keys.CopyName)
+			if !copyName && m.needsConfirm() {
+				return m.startConfirm(confirmCopy)
+			}
+			return m.handleCopy(copyName)
+		case key.Matches(msg, m.keys.ChangePassword):
+			m.wipeSecrets()
+			m.mainModel.viewState = changePasswordView
+			return m.mainModel.changePasswordView, tea.Batch(tea.WindowSize(), textinput.Blink, m.mainModel.changePasswordView.Init(), SendDecryptedVaultKeyCmd(m.decryptedVaultKey), SendVaultCmd(m.vault))
+		case key.Matches(msg, m.keys.KeySlots):
+			m.wipeSecrets()
+			m.mainModel.viewState = keySlotsView
+			return m.mainModel.keySlotsView, tea.Batch(tea.WindowSize(), m.mainModel.keySlotsView.Init(), SendDecryptedVaultKeyCmd(m.decryptedVaultKey), SendVaultCmd(m.vault))
+		case key.Matches(msg, m.keys.RotateKey):
+			m.wipeSecrets()
+			m.mainModel.viewState = rotateKeyView
+			return m.mainModel.rotateKeyView, tea.Batch(tea.WindowSize(), textinput.Blink, m.mainModel.rotateKeyView.Init(), SendDecryptedVaultKeyCmd(m.decryptedVaultKey), SendVaultCmd(m.vault))
 		}
 	case SendVaultMsg:
 		m.vault = msg.VaultSended
+		m.wipeSecrets()
 		m.decryptedVaultSecrets = make([]DecryptedSecret, len(m.vault.Secrets))
-		return m, nil
+		m.revealed = -1
+		m.confirming = confirmNone
+		// Pick up the countdown of a copy made before leaving the view
+		return m, m.mainModel.startClipboardCountdown()
 	case SendDecryptedVaultKeyMsg:
-		m.decryptedVaultKey = msg
-		m.decryptVaultSecrets()
+		m.decryptedVaultKey = msg.VaultKey
+		if err := m.decryptVaultSecrets(); err != nil {
+			m.errorMsg = fmt.Sprintf("Error decrypting secrets: %v", err)
+		}
+		return m, nil
+	case SendConfirmationMsg:
+		m.confirmationMsg = string(msg)
 		return m, nil
+	case SendFailedAttemptsMsg:
+		m.errorMsg = failedAttemptsNotice(int(msg))
+		return m, nil
+	case ClipboardTickMsg:
+		if msg.generation != m.mainModel.clipboardGeneration {
+			return m, nil
+		}
+		return m, clipboardTick(msg.generation)
+	case RevealTickMsg:
+		return m.masked()
 	case tea.WindowSizeMsg:
 		m.w = msg.Width
 		m.h = msg.Height
 		m.help.Width = msg.Width
 	}
-	return m, nil
+	return m, m.updateConfirmInputs(msg)
+}
+
+// Sending a confirmation message back to the vault view after an action
+// finished in another view.
+type SendConfirmationMsg string
+
+func SendConfirmationCmd(confirmation string) tea.Cmd {
+	return func() tea.Msg {
+		return SendConfirmationMsg(confirmation)
+	}
 }
 
 type DecryptedSecret struct {
 	SecretName string
-	SecretText string
+	SecretText *LockedBuffer
 }
 
-func (m VaultModel) decryptVaultSecrets() {
+func (m VaultModel) decryptVaultSecrets() error {
+	var err error
 	for i := range m.vault.Secrets {
-		m.decryptedVaultSecrets[i].SecretName, m.decryptedVaultSecrets[i].SecretText = DecryptSecretData(m.vault.Secrets[i].EncodedEncryptedName, m.vault.Secrets[i].EncodedEncryptedText, m.decryptedVaultKey)
+		m.decryptedVaultSecrets[i].SecretName, m.decryptedVaultSecrets[i].SecretText, err = RevealSecret(m.vault, m.vault.Secrets[i], m.decryptedVaultKey.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipeSecrets destroys the revealed secrets. They're decrypted again when the
+// vault is sent back to this view.
+func (m VaultModel) wipeSecrets() {
+	for _, secret := range m.decryptedVaultSecrets {
+		secret.SecretText.Destroy()
 	}
 }
 
 func (m VaultModel) handleDelete() (tea.Model, tea.Cmd) {
-	m.vault.Secrets = append(m.vault.Secrets[:m.cursor], m.vault.Secrets[m.cursor+1:]...)
-	m.decryptedVaultSecrets = append(m.decryptedVaultSecrets[:m.cursor], m.decryptedVaultSecrets[m.cursor+1:]...)
-	updateVaultByte, err := json.Marshal(m.vault)
-	if err != nil {
+	// The vault read is kept intact for the conflict view
+	deleted := m.vault
+	deleted.Secrets = slices.Delete(slices.Clone(m.vault.Secrets), m.cursor, m.cursor+1)
+	saved, err := SaveVault(deleted)
+	if model, cmd, ok := m.mainModel.vaultConflict(err, m.vault, m.decryptedVaultKey); ok {
+		m.wipeSecrets()
+		return model, cmd
+	} else if err != nil {
 		m.errorMsg = fmt.Sprintf("Error deleting secret: %v", err)
 		return m, nil
 	}
-	err = os.WriteFile(fmt.Sprintf("%s%s.json", VAULTSPATH, m.vault.Name), updateVaultByte, 0644)
-	if err != nil {
-		m.errorMsg = fmt.Sprintf("Error deleting secret: %v", err)
-	}
+	m.decryptedVaultSecrets[m.cursor].SecretText.Destroy()
+	m.decryptedVaultSecrets = slices.Delete(m.decryptedVaultSecrets, m.cursor, m.cursor+1)
+	m.vault = saved
 	// reset cursor
 	m.cursor = 0
+	m.revealed = -1
 	m.confirmationMsg = "Secret deleted successfully"
 	return m, nil
 }
+
+// handleEdit opens the create secret form filled in with the highlighted
+// secret.
+func (m VaultModel) handleEdit() (tea.Model, tea.Cmd) {
+	secret := m.decryptedVaultSecrets[m.cursor]
+	editCmd := SendEditSecretCmd(m.cursor, secret.SecretName, secret.SecretText.String(), m.vault.Secrets[m.cursor].Sensitive)
+	m.wipeSecrets()
+	m.mainModel.viewState = createSecretView
+	return m.mainModel.createSecretView, tea.Batch(tea.WindowSize(), textinput.Blink, m.mainModel.createSecretView.Init(), SendDecryptedVaultKeyCmd(m.decryptedVaultKey), SendVaultCmd(m.vault), editCmd)
+}
+
+// handleCopy copies the text or the name of the selected secret. It's cleared
+// from the clipboard after the timeout of the vault.
+func (m VaultModel) handleCopy(name bool) (tea.Model, tea.Cmd) {
+	secret := m.decryptedVaultSecrets[m.cursor]
+	text, what := secret.SecretText.String(), "Secret text"
+	if name {
+		text, what = secret.SecretName, "Name"
+	}
+	if err := CopyToClipboard(text, what, m.vault.ClipboardClearTimeout()); err != nil {
+		m.errorMsg = fmt.Sprintf("Error copying to the clipboard: %v", err)
+		return m, nil
+	}
+	m.errorMsg = ""
+	m.confirmationMsg = fmt.Sprintf("Copied %s of %s", strings.ToLower(what), secret.SecretName)
+
+	return m, m.mainModel.startClipboardCountdown()
+}
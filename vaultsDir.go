@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The vaults live in one directory, taken from the first of:
+//   - the --vaults-dir flag
+//   - the CIPHERY_VAULTS_DIR environment variable
+//   - VaultsDir in the config file, ciphery/config.json in the user config
+//     directory, see configPath
+//   - $XDG_DATA_HOME/ciphery/vaults, ~/.local/share/ciphery/vaults when
+//     XDG_DATA_HOME isn't set
+//
+// It's created with 0700 permissions when missing. A path ending in .db is a
+// single file holding every vault instead, see singleFileStore.go, and its
+// directory is the one created. setupVaultsDir opens the store there before
+// the app or a command runs.
+
+// config is the optional config file of ciphery.
+type config struct {
+	// VaultsDir is the vaults directory. A relative one is taken from the
+	// directory of the config file.
+	VaultsDir string `json:"VaultsDir,omitempty"`
+}
+
+// configPath is ciphery/config.json in os.UserConfigDir: $XDG_CONFIG_HOME or
+// ~/.config on Linux, ~/Library/Application Support on macOS and %AppData%
+// on Windows. The per-machine state of the vaults is next to it, see
+// vaultStatePath.
+func configPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "ciphery", "config.json"), nil
+}
+
+// loadConfig reads the config file. Without one the config is empty.
+func loadConfig() (config, error) {
+	var c config
+	path, err := configPath()
+	if err != nil {
+		return c, nil
+	}
+	configByte, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(configByte, &c); err != nil {
+		return c, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if c.VaultsDir != "" {
+		c.VaultsDir = expandHome(c.VaultsDir)
+		if !filepath.IsAbs(c.VaultsDir) {
+			c.VaultsDir = filepath.Join(filepath.Dir(path), c.VaultsDir)
+		}
+	}
+	return c, nil
+}
+
+// dataDir is $XDG_DATA_HOME, or its default under the home directory.
+func dataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// expandHome replaces a leading ~ with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// resolveVaultsDir picks the vaults directory, flagDir being the value of
+// --vaults-dir.
+func resolveVaultsDir(flagDir string) (string, error) {
+	dir := flagDir
+	if dir == "" {
+		dir = os.Getenv("CIPHERY_VAULTS_DIR")
+	}
+	if dir == "" {
+		c, err := loadConfig()
+		if err != nil {
+			return "", err
+		}
+		dir = c.VaultsDir
+	}
+	if dir == "" {
+		data, err := dataDir()
+		if err != nil {
+			return "", fmt.Errorf("no vaults directory, set CIPHERY_VAULTS_DIR: %w", err)
+		}
+		dir = filepath.Join(data, "ciphery", "vaults")
+	}
+	return filepath.Abs(expandHome(dir))
+}
+
+// setupVaultsDir resolves the vaults directory, creates it if needed and
+// opens the store in it.
+func setupVaultsDir(flagDir string) error {
+	dir, err := resolveVaultsDir(flagDir)
+	if err != nil {
+		return err
+	}
+	vaults := openStore(dir)
+	if _, ok := vaults.(SingleFileStore); ok {
+		dir = filepath.Dir(dir)
+	}
+	if err := os.MkdirAll(dir, vaultDirMode); err != nil {
+		return err
+	}
+	store = vaults
+	return nil
+}
+
+// parseGlobalFlags parses the flags given before the command and returns the
+// rest of the arguments.
+func parseGlobalFlags(args []string) ([]string, string, error) {
+	flags := flag.NewFlagSet("ciphery", flag.ContinueOnError)
+	flags.Usage = func() {}
+	dir := flags.String("vaults-dir", "", "directory of the vaults")
+	if err := flags.Parse(args); err != nil {
+		return nil, "", err
+	}
+	return flags.Args(), *dir, nil
+}
@@ -1,11 +1,9 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"os"
-	"path/filepath"
+	"slices"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
@@ -14,11 +12,13 @@ import (
 )
 
 type VaultsModel struct {
-	keys            keyMap
-	help            help.Model
-	cursor          int
-	w, h            int
-	vaults          []Vault
+	keys   keyMap
+	help   help.Model
+	cursor int
+	w, h   int
+	vaults []Vault
+	// Files and directories of the store other users can get at
+	permissions     []PermissionProblem
 	errorMsg        string
 	confirmationMsg string
 	mainModel       *mainModel
@@ -28,18 +28,21 @@ func InitialVaultsModel(mainMdl *mainModel) VaultsModel {
 	m := VaultsModel{keys: keysVaults,
 		help:      help.New(),
 		mainModel: mainMdl}
-	m.vaults = m.GetVaults()
+	m.vaults, m.permissions, _ = m.GetVaults()
 
 	return m
 }
 
 type UpdateVaultsMsg struct {
-	Vaults []Vault
+	Vaults      []Vault
+	Permissions []PermissionProblem
+	// Err describes the vault files that couldn't be read.
+	Err error
 }
 
-func UpdateVaultsCmd(vaults []Vault) tea.Cmd {
+func UpdateVaultsCmd(vaults []Vault, permissions []PermissionProblem, err error) tea.Cmd {
 	return func() tea.Msg {
-		return UpdateVaultsMsg{Vaults: vaults}
+		return UpdateVaultsMsg{Vaults: vaults, Permissions: permissions, Err: err}
 	}
 }
 
@@ -51,6 +54,7 @@ func (m VaultsModel) View() string {
 	s := ""
 	s += titleStyle.Render(fmt.Sprintf("Vaults that you've %s", highlightStyle.Render("created.")))
 	s += "\n"
+	s += m.permissionsBanner()
 
 	// rendering vaults list
 	if len(m.vaults) > 0 {
@@ -60,7 +64,11 @@ func (m VaultsModel) View() string {
 			if m.cursor == i {
 				style = listItemHighlightStyle
 			}
-			v += style.Render(fmt.Sprintf("%s\n%s", vault.Name, listItemDescriptionStyle.Render(vault.Description)))
+			description := vault.Description
+			if vault.IsLocked() {
+				description = fmt.Sprintf("%s, unlock to see more", vault.FileName())
+			}
+			v += style.Render(fmt.Sprintf("%s\n%s", vault.DisplayName(), listItemDescriptionStyle.Render(description)))
 			v += "\n"
 		}
 		s += listStyle.Render(v)
@@ -102,6 +110,8 @@ func (m VaultsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		case key.Matches(msg, m.keys.Delete):
 			return m.handleDelete()
+		case key.Matches(msg, m.keys.FixPermissions):
+			return m.handleFixPermissions()
 		case key.Matches(msg, m.keys.Up):
 			if m.cursor > 0 {
 				m.cursor--
@@ -120,7 +130,10 @@ func (m VaultsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case UpdateVaultsMsg:
 		m.vaults = msg.Vaults
-		if len(m.vaults) == 0 {
+		m.permissions = msg.Permissions
+		if msg.Err != nil {
+			m.errorMsg = fmt.Sprintf("Some vaults couldn't be read:\n%v", msg.Err)
+		} else if len(m.vaults) == 0 {
 			m.errorMsg = "There is no vaults created. Go back and create one!"
 		} else {
 			m.errorMsg = ""
@@ -133,42 +146,36 @@ func (m VaultsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-func (m VaultsModel) GetVaults() []Vault {
+// GetVaults reads every vault in the store and checks who else can get at
+// them. Vaults that can't be read are skipped and reported in the error.
+func (m VaultsModel) GetVaults() ([]Vault, []PermissionProblem, error) {
 	vaults := []Vault{}
+	var errs []error
 
-	files, err := os.ReadDir(VAULTSPATH)
+	permissions, err := CheckStorePermissions()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("can't check permissions: %v", err))
+	}
+	names, err := store.List()
 	if err != nil {
-		log.Fatal(err)
+		return vaults, permissions, errors.Join(append(errs, err)...)
 	}
-	for _, file := range files {
-		if file.IsDir() {
-			// Skip directories
+	for _, name := range names {
+		vault, err := LoadVault(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("can't read %s: %v", name, err))
 			continue
 		}
-		// Reading all the .jsons and pushing them to a list
-		if filepath.Ext(file.Name()) == ".json" {
-			filePath := filepath.Join(VAULTSPATH, file.Name())
-			fileData, err := os.ReadFile(filePath)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			var vault Vault
-			if err := json.Unmarshal(fileData, &vault); err != nil {
-				log.Fatal(err)
-			}
-			vaults = append(vaults, vault)
-		}
+		vaults = append(vaults, vault)
 	}
-	return vaults
+	return vaults, permissions, errors.Join(errs...)
 }
 func (m VaultsModel) handleDelete() (tea.Model, tea.Cmd) {
-	err := os.Remove(fmt.Sprintf("%s%s.json", VAULTSPATH, m.vaults[m.cursor].Name))
-	if err != nil {
+	if err := store.Delete(m.vaults[m.cursor].FileName()); err != nil {
 		m.errorMsg = fmt.Sprintf("Error deleting vault: %v", err)
 	} else {
 		m.errorMsg = ""
-		m.confirmationMsg = fmt.Sprintf("Vault %s deleted successfully", m.vaults[m.cursor].Name)
+		m.confirmationMsg = fmt.Sprintf("Vault %s deleted successfully", m.vaults[m.cursor].FileName())
 	}
 
 	m.vaults = append(m.vaults[:m.cursor], m.vaults[m.cursor+1:]...)
@@ -178,3 +185,46 @@ func (m VaultsModel) handleDelete() (tea.Model, tea.Cmd) {
 	}
 	return m, nil
 }
+
+// permissionsBanner warns about vault files other users can get at.
+func (m VaultsModel) permissionsBanner() string {
+	if len(m.permissions) == 0 {
+		return ""
+	}
+	s := errorStyle.Render("Other users can get at your vaults!")
+	s += "\n"
+	for i, problem := range m.permissions {
+		if i == 3 {
+			s += fmt.Sprintf("and %d more\n", len(m.permissions)-i)
+			break
+		}
+		s += problem.String() + "\n"
+	}
+	if slices.ContainsFunc(m.permissions, PermissionProblem.Fixable) {
+		s += fmt.Sprintf("Press %s to make them private.\n", highlightStyle.Render("f"))
+	} else {
+		s += "Change the owner of these by hand.\n"
+	}
+	return s + "\n"
+}
+
+func (m VaultsModel) handleFixPermissions() (tea.Model, tea.Cmd) {
+	if len(m.permissions) == 0 {
+		return m, nil
+	}
+	if err := FixStorePermissions(); err != nil {
+		m.errorMsg = fmt.Sprintf("Error fixing permissions: %v", err)
+		return m, nil
+	}
+	m.errorMsg = ""
+	m.confirmationMsg = "Permissions fixed, only you can get at your vaults now"
+	permissions, err := CheckStorePermissions()
+	if err != nil {
+		m.errorMsg = fmt.Sprintf("Error checking permissions: %v", err)
+	}
+	m.permissions = permissions
+	if len(m.permissions) > 0 {
+		m.confirmationMsg = "Permissions fixed where possible"
+	}
+	return m, nil
+}